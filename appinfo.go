@@ -0,0 +1,265 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"unicode/utf16"
+)
+
+// appInfo, for -app-info, prints the manifests of zip based application
+// packages while extracting: META-INF/MANIFEST.MF of a JAR (or APK), the
+// AndroidManifest.xml of an APK and the Info.plist of an IPA.
+var appInfo = false
+
+// printAppInfo prints the manifests found among files.
+func printAppInfo(files []*zip.File) {
+	for _, zf := range files {
+		var decode func([]byte) (string, error)
+		switch {
+		case zf.Name == "META-INF/MANIFEST.MF":
+			decode = func(data []byte) (string, error) { return string(data), nil }
+		case zf.Name == "AndroidManifest.xml":
+			decode = decodeAXML
+		case path.Base(zf.Name) == "Info.plist" && strings.HasPrefix(zf.Name, "Payload/") && strings.Count(zf.Name, "/") == 2:
+			decode = decodePlist
+		default:
+			continue
+		}
+
+		fmt.Printf("App manifest %s:\n", zf.Name)
+		text, err := readManifest(zf, decode)
+		if err != nil {
+			fmt.Printf("   (can't be read: %v)\n", err)
+			continue
+		}
+		for _, line := range strings.Split(strings.TrimRight(text, "\r\n"), "\n") {
+			fmt.Println("  ", strings.TrimRight(line, "\r"))
+		}
+	}
+}
+
+// maxManifestSize is the largest manifest read, as they're read whole.
+const maxManifestSize = 4 << 20
+
+func readManifest(zf *zip.File, decode func([]byte) (string, error)) (string, error) {
+	rc, err := openZipFile(zf)
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(io.LimitReader(rc, maxManifestSize))
+	if err != nil {
+		return "", err
+	}
+	return decode(data)
+}
+
+// decodePlist returns an XML property list as is. Binary ones, as most
+// IPAs have, aren't decoded.
+func decodePlist(data []byte) (string, error) {
+	if bytes.HasPrefix(data, []byte("bplist")) {
+		return "", errors.New("binary property list")
+	}
+	return string(data), nil
+}
+
+// Chunk types of Android's binary XML format.
+const (
+	axmlFile         = 0x0003
+	axmlStringPool   = 0x0001
+	axmlResourceMap  = 0x0180
+	axmlStartNS      = 0x0100
+	axmlStartElement = 0x0102
+	axmlEndElement   = 0x0103
+)
+
+// Value types of binary XML attributes.
+const (
+	axmlTypeReference = 0x01
+	axmlTypeString    = 0x03
+	axmlTypeIntDec    = 0x10
+	axmlTypeIntHex    = 0x11
+	axmlTypeBool      = 0x12
+)
+
+var errAXML = errors.New("invalid binary XML")
+
+// decodeAXML decodes the binary XML an APK's AndroidManifest.xml is
+// compiled to back into text. Attribute values that are resource
+// references are shown as their IDs, as resolving them needs the
+// resource table.
+func decodeAXML(data []byte) (string, error) {
+	le := binary.LittleEndian
+	if len(data) < 8 || le.Uint16(data) != axmlFile {
+		return "", errAXML
+	}
+
+	var strs []string
+	var resIDs []uint32
+	prefixes := make(map[uint32]string) // by namespace URI
+	str := func(i uint32) string {
+		if int(i) < len(strs) {
+			return strs[i]
+		}
+		return ""
+	}
+	attrName := func(i uint32) string {
+		if name := str(i); name != "" {
+			return name
+		}
+		if int(i) < len(resIDs) {
+			return fmt.Sprintf("attr_0x%08x", resIDs[i])
+		}
+		return fmt.Sprintf("attr_%d", i)
+	}
+
+	var b strings.Builder
+	depth := 0
+	for off := int(le.Uint16(data[2:])); off+8 <= len(data); {
+		typ := le.Uint16(data[off:])
+		headerSize := int(le.Uint16(data[off+2:]))
+		size := int(le.Uint32(data[off+4:]))
+		if size < 8 || off+size > len(data) || headerSize > size {
+			return "", errAXML
+		}
+		chunk := data[off : off+size]
+		off += size
+
+		switch typ {
+		case axmlStringPool:
+			var err error
+			if strs, err = axmlStrings(chunk, headerSize); err != nil {
+				return "", err
+			}
+		case axmlResourceMap:
+			for i := headerSize; i+4 <= len(chunk); i += 4 {
+				resIDs = append(resIDs, le.Uint32(chunk[i:]))
+			}
+		case axmlStartNS:
+			if len(chunk) >= 24 {
+				prefixes[le.Uint32(chunk[20:])] = str(le.Uint32(chunk[16:]))
+			}
+		case axmlStartElement:
+			if len(chunk) < 36 {
+				return "", errAXML
+			}
+			fmt.Fprintf(&b, "%s<%s", strings.Repeat("  ", depth), str(le.Uint32(chunk[20:])))
+			attrStart := 16 + int(le.Uint16(chunk[24:]))
+			attrSize := int(le.Uint16(chunk[26:]))
+			attrs := int(le.Uint16(chunk[28:]))
+			for i := 0; i < attrs; i++ {
+				a := attrStart + i*attrSize
+				if attrSize < 20 || a+20 > len(chunk) {
+					return "", errAXML
+				}
+				name := attrName(le.Uint32(chunk[a+4:]))
+				if prefix := prefixes[le.Uint32(chunk[a:])]; prefix != "" {
+					name = prefix + ":" + name
+				}
+				fmt.Fprintf(&b, " %s=%q", name, axmlValue(chunk[a+15], le.Uint32(chunk[a+16:]), str))
+			}
+			b.WriteString(">\n")
+			depth++
+		case axmlEndElement:
+			if len(chunk) < 24 {
+				return "", errAXML
+			}
+			if depth > 0 {
+				depth--
+			}
+			fmt.Fprintf(&b, "%s</%s>\n", strings.Repeat("  ", depth), str(le.Uint32(chunk[20:])))
+		}
+	}
+	return b.String(), nil
+}
+
+// axmlStrings reads the strings of a string pool chunk.
+func axmlStrings(chunk []byte, headerSize int) ([]string, error) {
+	le := binary.LittleEndian
+	if len(chunk) < 28 {
+		return nil, errAXML
+	}
+	count := int(le.Uint32(chunk[8:]))
+	utf8 := le.Uint32(chunk[16:])&0x100 != 0
+	start := int(le.Uint32(chunk[20:]))
+	if headerSize+4*count > len(chunk) || start > len(chunk) {
+		return nil, errAXML
+	}
+
+	strs := make([]string, count)
+	for i := range strs {
+		pos := start + int(le.Uint32(chunk[headerSize+4*i:]))
+		if pos >= len(chunk) {
+			return nil, errAXML
+		}
+		s := chunk[pos:]
+		if utf8 {
+			// The length in characters, then in bytes, each one
+			// byte or, with the high bit set, two.
+			skip := axmlLen8(s)
+			if skip >= len(s) {
+				return nil, errAXML
+			}
+			s = s[skip:]
+			n := int(s[0])
+			if n&0x80 != 0 && len(s) > 1 {
+				n = (n&0x7f)<<8 | int(s[1])
+				s = s[1:]
+			}
+			if 1+n > len(s) {
+				return nil, errAXML
+			}
+			strs[i] = string(s[1 : 1+n])
+			continue
+		}
+		if len(s) < 2 {
+			return nil, errAXML
+		}
+		n := int(le.Uint16(s))
+		s = s[2:]
+		if n&0x8000 != 0 && len(s) >= 2 {
+			n = (n&0x7fff)<<16 | int(le.Uint16(s))
+			s = s[2:]
+		}
+		if 2*n > len(s) {
+			return nil, errAXML
+		}
+		units := make([]uint16, n)
+		for j := range units {
+			units[j] = le.Uint16(s[2*j:])
+		}
+		strs[i] = string(utf16.Decode(units))
+	}
+	return strs, nil
+}
+
+// axmlLen8 returns the size of a one or two byte UTF-8 string length.
+func axmlLen8(s []byte) int {
+	if len(s) > 0 && s[0]&0x80 != 0 {
+		return 2
+	}
+	return 1
+}
+
+func axmlValue(typ byte, data uint32, str func(uint32) string) string {
+	switch typ {
+	case axmlTypeString:
+		return str(data)
+	case axmlTypeReference:
+		return fmt.Sprintf("@0x%08x", data)
+	case axmlTypeIntDec:
+		return fmt.Sprint(int32(data))
+	case axmlTypeIntHex:
+		return fmt.Sprintf("0x%x", data)
+	case axmlTypeBool:
+		return fmt.Sprint(data != 0)
+	default:
+		return fmt.Sprintf("(type 0x%02x) 0x%08x", typ, data)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// openCached returns the archive at url from the cache directory,
+// downloading it there first if it's not already present. Only complete
+// downloads are ever placed in the cache.
+func openCached(ctx context.Context, url string) (io.ReadCloser, error) {
+	cached := cachePath(url)
+	if fd, err := os.Open(cached); err == nil {
+		if verbose {
+			fmt.Println("Using cached", cached)
+		}
+		return fd, nil
+	}
+
+	resp, err := fetch(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// The size is enforced on what's actually read, as when extracting
+	// straight from the response.
+	var r io.Reader = resp.Body
+	var limit *sizeLimitReader
+	if maxSize > 0 {
+		limit = &sizeLimitReader{r: r, remain: maxSize}
+		r = limit
+	}
+	body := &readErrReader{r: r}
+	br := bufio.NewReader(body)
+	if err := checkNotHTML(br, resp.StatusCode); err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return nil, fmt.Errorf("creating cache: %v", err)
+	}
+	out, err := os.CreateTemp(cacheDir, ".download-*")
+	if err != nil {
+		return nil, fmt.Errorf("creating cache: %v", err)
+	}
+	_, err = io.Copy(out, br)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	switch {
+	case limit != nil && limit.err != nil:
+		err = limit.err
+	case body.err != nil:
+		// A download cut short, which may well go better next time.
+		err = &corruptError{body.err}
+	case err == nil:
+		err = os.Rename(out.Name(), cached)
+	}
+	if err != nil {
+		os.Remove(out.Name())
+		return nil, err
+	}
+
+	return os.Open(cached)
+}
+
+// cachePath returns the path url is cached at.
+func cachePath(url string) string {
+	return filepath.Join(cacheDir, fmt.Sprintf("%x", sha256.Sum256([]byte(url))))
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+)
+
+// checksum is an expected digest of the downloaded archive.
+type checksum struct {
+	algo    string
+	newHash func() hash.Hash
+	want    []byte
+}
+
+// expected is the checksum the archive is verified against, if any.
+var expected *checksum
+
+var checksumAlgos = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha384": sha512.New384,
+	"sha512": sha512.New,
+}
+
+// weakAlgos are broken as protection against deliberate tampering, though
+// still fine for catching corruption.
+var weakAlgos = map[string]bool{
+	"md5":  true,
+	"sha1": true,
+}
+
+// parseChecksum parses a hex digest for the given algorithm.
+func parseChecksum(algo, digest string) (*checksum, error) {
+	newHash, ok := checksumAlgos[algo]
+	if !ok {
+		return nil, fmt.Errorf("unknown checksum algorithm %q", algo)
+	}
+	want, err := hex.DecodeString(strings.TrimSpace(digest))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s digest: %v", algo, err)
+	}
+	if len(want) != newHash().Size() {
+		return nil, fmt.Errorf("invalid %s digest: should be %d hex digits", algo, 2*newHash().Size())
+	}
+	return &checksum{algo: algo, newHash: newHash, want: want}, nil
+}
+
+// detectChecksum parses a hex digest, inferring the algorithm from its
+// length.
+func detectChecksum(digest string) (*checksum, error) {
+	digest = strings.TrimSpace(digest)
+	switch len(digest) {
+	case 2 * md5.Size:
+		return parseChecksum("md5", digest)
+	case 2 * sha1.Size:
+		return parseChecksum("sha1", digest)
+	case 2 * sha256.Size:
+		return parseChecksum("sha256", digest)
+	case 2 * sha512.Size384:
+		return parseChecksum("sha384", digest)
+	case 2 * sha512.Size:
+		return parseChecksum("sha512", digest)
+	default:
+		return nil, fmt.Errorf("can't infer checksum algorithm from a %d digit digest", len(digest))
+	}
+}
+
+// fragmentChecksum returns the checksum given as an "algo=hex" pair in a
+// URL fragment, or nil if there is none.
+func fragmentChecksum(fragment string) (*checksum, error) {
+	for _, pair := range strings.Split(fragment, "&") {
+		algo, digest, ok := strings.Cut(pair, "=")
+		if _, known := checksumAlgos[algo]; ok && known {
+			return parseChecksum(algo, digest)
+		}
+	}
+	return nil, nil
+}
+
+// verifyingReader verifies the checksum of what's been hashed into h on
+// reaching EOF, failing the read on a mismatch.
+type verifyingReader struct {
+	r io.Reader
+	h hash.Hash
+}
+
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	n, err := v.r.Read(p)
+	if err == io.EOF {
+		if verr := expected.verify(v.h); verr != nil {
+			return n, verr
+		}
+	}
+	return n, err
+}
+
+// checksumError is a mismatch between the expected and the actual digest.
+type checksumError struct {
+	algo      string
+	want, got []byte
+}
+
+func (e *checksumError) Error() string {
+	return fmt.Sprintf("%s checksum mismatch: expected %x, got %x", e.algo, e.want, e.got)
+}
+
+// verify returns a *checksumError unless h has the expected sum.
+func (c *checksum) verify(h hash.Hash) error {
+	if got := h.Sum(nil); !bytes.Equal(got, c.want) {
+		return &checksumError{algo: c.algo, want: c.want, got: got}
+	}
+	if verbose {
+		fmt.Printf("Verified %s checksum %x\n", c.algo, c.want)
+	}
+	return nil
+}
+
+// asyncHash hashes what's written to it in a goroutine of its own, so that
+// hashing a download runs alongside the decompression and extraction of
+// it instead of taking turns with them.
+type asyncHash struct {
+	h      hash.Hash
+	queue  chan []byte
+	done   chan struct{}
+	closed bool
+}
+
+func newAsyncHash(h hash.Hash) *asyncHash {
+	a := &asyncHash{
+		h:     h,
+		queue: make(chan []byte, 16),
+		done:  make(chan struct{}),
+	}
+	go func() {
+		defer close(a.done)
+		for b := range a.queue {
+			a.h.Write(b)
+		}
+	}()
+	return a
+}
+
+// Write queues a copy of p for hashing; the caller may reuse p.
+func (a *asyncHash) Write(p []byte) (int, error) {
+	a.queue <- append([]byte(nil), p...)
+	return len(p), nil
+}
+
+// finish waits for everything written so far to be hashed and returns the
+// hash. Nothing may be written after.
+func (a *asyncHash) finish() hash.Hash {
+	if !a.closed {
+		close(a.queue)
+		a.closed = true
+	}
+	<-a.done
+	return a.h
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestChecksumCoversBody(t *testing.T) {
+	archive := tarData(t, tarEntry{name: "file", body: "content"})
+	// Trailing data after the end of the tar, which extraction doesn't
+	// need but the digest covers.
+	body := append(append([]byte(nil), archive...), make([]byte, 10240)...)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/a.tar", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(body)
+	})
+	mux.HandleFunc("/latest", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/a.tar", http.StatusFound)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	sum := func(data []byte) string {
+		s := sha256.Sum256(data)
+		return hex.EncodeToString(s[:])
+	}
+	cases := []struct {
+		name     string
+		path     string
+		digest   string
+		mismatch bool
+	}{
+		{"whole body", "/a.tar", sum(body), false},
+		{"whole body after redirect", "/latest", sum(body), false},
+		{"only the tar", "/latest", sum(archive), true},
+		{"something else", "/latest", sum([]byte("other")), true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var err error
+			if expected, err = parseChecksum("sha256", tc.digest); err != nil {
+				t.Fatal(err)
+			}
+			defer func() { expected = nil }()
+			_, err = downloadTest(context.Background(), t, srv.URL+tc.path, 0)
+			var ce *checksumError
+			if got := errors.As(err, &ce); got != tc.mismatch {
+				t.Errorf("error %v, expected a mismatch: %v", err, tc.mismatch)
+			} else if !got && err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestAsyncHash(t *testing.T) {
+	data := []byte(strings.Repeat("some data to hash ", 10000))
+	for _, chunk := range []int{1, 100, 4096, len(data)} {
+		h := newAsyncHash(sha256.New())
+		buf := make([]byte, chunk)
+		for i := 0; i < len(data); i += chunk {
+			// Reusing the buffer, as io.Copy does.
+			n := copy(buf, data[i:])
+			h.Write(buf[:n])
+		}
+		want := sha256.Sum256(data)
+		if got := h.finish().Sum(nil); string(got) != string(want[:]) {
+			t.Errorf("chunks of %d: digest %x, expected %x", chunk, got, want)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// client is shared by every download in an invocation, so that connections
+// to the same host are kept alive and reused instead of paying for a new
+// TCP and TLS handshake per request. It's set up in main once the flags
+// are parsed.
+var client *http.Client
+
+// tlsServerName, when set, is the name verified against the server
+// certificate instead of the host name in the URL.
+var tlsServerName = ""
+
+// tlsMinVersion is the oldest TLS version accepted, or zero for Go's
+// default.
+var tlsMinVersion uint16
+
+// tlsVersions are the values accepted by -tls-min-version.
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// dialNetwork is "tcp4" or "tcp6" to force connections over that address
+// family, or "tcp" for either.
+var dialNetwork = "tcp"
+
+// maxConnsPerHost limits the number of connections to any one host; zero
+// means no limit.
+var maxConnsPerHost = 0
+
+// proxyURL, when set, is the proxy used for all requests instead of the
+// one from the environment. Both may be an http://, https:// or, with
+// optional user:pass@ authentication, socks5:// proxy.
+var proxyURL *url.URL
+
+// connectTimeout, for -connect-timeout, bounds both connecting and the TLS
+// handshake, separately from the -time-limit on the whole run. Zero keeps
+// the defaults of 30 and 10 seconds.
+var connectTimeout time.Duration
+
+// maxRedirects is the number of redirects followed before giving up. With
+// noRedirect, a redirect isn't followed but returned as an error.
+var (
+	maxRedirects = 10
+	noRedirect   = false
+)
+
+func newClient() *http.Client {
+	proxy := http.ProxyFromEnvironment
+	if proxyURL != nil {
+		proxy = http.ProxyURL(proxyURL)
+	}
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	handshakeTimeout := 10 * time.Second
+	if connectTimeout > 0 {
+		dialer.Timeout = connectTimeout
+		handshakeTimeout = connectTimeout
+	}
+	return &http.Client{
+		CheckRedirect: checkRedirect,
+		Transport: &http.Transport{
+			Proxy: proxy,
+			DialContext: func(ctx context.Context, _, addr string) (net.Conn, error) {
+				return dialer.DialContext(ctx, dialNetwork, addr)
+			},
+			ForceAttemptHTTP2:     true,
+			MaxIdleConns:          100,
+			MaxIdleConnsPerHost:   16, // the default of 2 is low when fetching many files from one mirror
+			MaxConnsPerHost:       maxConnsPerHost,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   handshakeTimeout,
+			ExpectContinueTimeout: time.Second,
+			TLSClientConfig: &tls.Config{
+				ServerName: tlsServerName,
+				MinVersion: tlsMinVersion,
+			},
+		},
+	}
+}
+
+// bearerToken, when set, is sent as a bearer token with every request.
+// The client drops it when redirected to a different host.
+var bearerToken = ""
+
+// authorize adds the -token to req.
+func authorize(req *http.Request) {
+	if bearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+bearerToken)
+	}
+}
+
+// errTooManyRedirects isn't retried, as the redirects would be the same.
+var errTooManyRedirects = errors.New("too many redirects")
+
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if noRedirect {
+		// Leaves the response for responseError to report.
+		return http.ErrUseLastResponse
+	}
+	if len(via) >= maxRedirects {
+		return fmt.Errorf("%w (stopped after %d)", errTooManyRedirects, maxRedirects)
+	}
+	if verbose {
+		fmt.Println("Redirected to", req.URL)
+	}
+	return nil
+}
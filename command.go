@@ -0,0 +1,39 @@
+package main
+
+import (
+	"flag"
+	"os"
+)
+
+// Besides the plain "dl URL", which downloads and extracts in one go, the
+// two steps can be run separately as "dl fetch URL -o file" and "dl extract
+// FILE". The subcommands accept flags after the positional argument.
+const (
+	commandFetch   = "fetch"
+	commandExtract = "extract"
+)
+
+// localSource is set by the extract subcommand, making the source a path
+// to a local archive instead of a URL.
+var localSource = false
+
+// parseCommandLine parses the command line flags and returns the
+// subcommand, if any, and the positional arguments.
+func parseCommandLine() (string, []string) {
+	args := os.Args[1:]
+	if len(args) == 0 || args[0] != commandFetch && args[0] != commandExtract {
+		flag.Parse()
+		return "", flag.Args()
+	}
+
+	command := args[0]
+	var positional []string
+	for args = args[1:]; ; args = args[1:] {
+		flag.CommandLine.Parse(args) // exits on error
+		args = flag.Args()
+		if len(args) == 0 {
+			return command, positional
+		}
+		positional = append(positional, args[0])
+	}
+}
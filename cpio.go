@@ -0,0 +1,275 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// cpio archives are read into tar headers, so that the entries go through
+// the same handling as those of a tar archive. The "new" (SVR4) format, with
+// and without checksums, and the portable "odc" format are supported; the
+// old binary format isn't.
+
+var (
+	magicCpioNewc = []byte("070701")
+	magicCpioCRC  = []byte("070702")
+	magicCpioOdc  = []byte("070707")
+)
+
+const cpioTrailer = "TRAILER!!!"
+
+// cpioMaxLink is the longest symlink target accepted, like the longest name.
+const cpioMaxLink = 4096
+
+// cpioSocket is the type flag given to socket entries, which have no tar
+// equivalent and are skipped.
+const cpioSocket = 's'
+
+// File type bits of the cpio mode field.
+const (
+	cpioTypeMask    = 0170000
+	cpioTypeFifo    = 0010000
+	cpioTypeChar    = 0020000
+	cpioTypeDir     = 0040000
+	cpioTypeBlock   = 0060000
+	cpioTypeReg     = 0100000
+	cpioTypeSymlink = 0120000
+	cpioTypeSocket  = 0140000
+)
+
+func isCpio(br *bufio.Reader) bool {
+	return hasMagic(br, magicCpioNewc) || hasMagic(br, magicCpioCRC) || hasMagic(br, magicCpioOdc)
+}
+
+// cpioReader reads the entries of a cpio archive. Like a tar.Reader, Next
+// advances to the next entry and Read reads its contents.
+type cpioReader struct {
+	r       *bufio.Reader
+	remain  int64 // unread bytes of the current entry
+	pad     int64 // padding following the current entry
+	queued  []*tar.Header
+	pending map[string][]string // hard link names awaiting their data, by inode
+	links   map[string]string   // name holding the data, by inode
+}
+
+func newCpioReader(r io.Reader) *cpioReader {
+	return &cpioReader{
+		r:       bufio.NewReader(r),
+		pending: make(map[string][]string),
+		links:   make(map[string]string),
+	}
+}
+
+func (c *cpioReader) Read(p []byte) (int, error) {
+	if c.remain <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > c.remain {
+		p = p[:c.remain]
+	}
+	n, err := c.r.Read(p)
+	c.remain -= int64(n)
+	if err == io.EOF && c.remain > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// Next skips the rest of the current entry and returns the header of the
+// next one, or io.EOF at the trailer.
+func (c *cpioReader) Next() (*tar.Header, error) {
+	if len(c.queued) > 0 {
+		hdr := c.queued[0]
+		c.queued = c.queued[1:]
+		return hdr, nil
+	}
+
+	for {
+		if _, err := io.CopyN(io.Discard, c.r, c.remain+c.pad); err != nil {
+			return nil, unexpected(err)
+		}
+		c.remain, c.pad = 0, 0
+
+		hdr, ino, nlink, err := c.readHeader()
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Name == cpioTrailer {
+			return nil, io.EOF
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeSymlink:
+			// The target is the entry's contents, which may claim
+			// any size at all.
+			if c.remain > cpioMaxLink {
+				return nil, fmt.Errorf("%s: invalid symlink target length %d", hdr.Name, c.remain)
+			}
+			target, err := io.ReadAll(io.LimitReader(c.r, c.remain))
+			if err != nil {
+				return nil, err
+			}
+			if int64(len(target)) < c.remain {
+				return nil, io.ErrUnexpectedEOF
+			}
+			hdr.Linkname = string(target)
+			hdr.Size = 0
+			c.remain = 0
+		case tar.TypeReg:
+			if nlink <= 1 {
+				break
+			}
+			// Hard links share an inode. The new format stores the
+			// data with the last of them only, the odc format with
+			// all of them; either way, link the others to the one
+			// extracted with the data.
+			if first, ok := c.links[ino]; ok {
+				hdr.Typeflag = tar.TypeLink
+				hdr.Linkname = first
+				hdr.Size = 0
+				break
+			}
+			if hdr.Size == 0 && len(c.pending[ino])+1 < nlink {
+				c.pending[ino] = append(c.pending[ino], hdr.Name)
+				continue
+			}
+			c.links[ino] = hdr.Name
+			for _, name := range c.pending[ino] {
+				c.queued = append(c.queued, &tar.Header{
+					Typeflag: tar.TypeLink,
+					Name:     name,
+					Linkname: hdr.Name,
+					Mode:     hdr.Mode,
+					ModTime:  hdr.ModTime,
+				})
+			}
+			delete(c.pending, ino)
+		case cpioSocket:
+			// Sockets can't be meaningfully extracted.
+			continue
+		}
+		return hdr, nil
+	}
+}
+
+// readHeader reads an entry header and its name, and sets up the reader for
+// the entry's contents. It returns the header along with the inode (as a
+// key unique within the archive) and the link count.
+func (c *cpioReader) readHeader() (*tar.Header, string, int, error) {
+	magic, err := c.r.Peek(6)
+	if err != nil {
+		return nil, "", 0, unexpected(err)
+	}
+
+	var fields []int64
+	var ino string
+	var nameSize, headerSize, align int64
+	switch string(magic) {
+	case string(magicCpioNewc), string(magicCpioCRC):
+		// ino, mode, uid, gid, nlink, mtime, filesize, devmajor,
+		// devminor, rdevmajor, rdevminor, namesize, check; 8 hex
+		// digits each. Names and contents are padded to four bytes.
+		buf := make([]byte, 110)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			return nil, "", 0, unexpected(err)
+		}
+		for i := 6; i < len(buf); i += 8 {
+			v, err := strconv.ParseInt(string(buf[i:i+8]), 16, 64)
+			if err != nil {
+				return nil, "", 0, fmt.Errorf("invalid header field %q", buf[i:i+8])
+			}
+			fields = append(fields, v)
+		}
+		ino = fmt.Sprintf("%x:%x:%x", fields[7], fields[8], fields[0])
+		nameSize = fields[11]
+		fields = []int64{fields[1], fields[2], fields[3], fields[4], fields[5], fields[6]}
+		headerSize, align = 110, 4
+	case string(magicCpioOdc):
+		// dev, ino, mode, uid, gid, nlink, rdev (6 octal digits each),
+		// mtime (11), namesize (6), filesize (11). No padding.
+		buf := make([]byte, 76)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			return nil, "", 0, unexpected(err)
+		}
+		widths := []int{6, 6, 6, 6, 6, 6, 6, 11, 6, 11}
+		var raw []int64
+		off := 6
+		for _, w := range widths {
+			v, err := strconv.ParseInt(string(buf[off:off+w]), 8, 64)
+			if err != nil {
+				return nil, "", 0, fmt.Errorf("invalid header field %q", buf[off:off+w])
+			}
+			raw = append(raw, v)
+			off += w
+		}
+		ino = fmt.Sprintf("%o:%o", raw[0], raw[1])
+		fields = []int64{raw[2], raw[3], raw[4], raw[5], raw[7], raw[9]}
+		nameSize = raw[8]
+		headerSize, align = 76, 1
+	default:
+		return nil, "", 0, fmt.Errorf("invalid header magic %q", magic)
+	}
+	mode, uid, gid, nlink, mtime, size := fields[0], fields[1], fields[2], fields[3], fields[4], fields[5]
+
+	if nameSize < 1 || nameSize > 4096 {
+		return nil, "", 0, fmt.Errorf("invalid name length %d", nameSize)
+	}
+	name := make([]byte, nameSize+padding(headerSize+nameSize, align))
+	if _, err := io.ReadFull(c.r, name); err != nil {
+		return nil, "", 0, unexpected(err)
+	}
+
+	hdr := &tar.Header{
+		Name:    string(name[:nameSize-1]),
+		Mode:    mode &^ cpioTypeMask,
+		Uid:     int(uid),
+		Gid:     int(gid),
+		Size:    size,
+		ModTime: time.Unix(mtime, 0),
+		Format:  tar.FormatPAX,
+	}
+	switch mode & cpioTypeMask {
+	case cpioTypeReg:
+		hdr.Typeflag = tar.TypeReg
+	case cpioTypeDir:
+		hdr.Typeflag = tar.TypeDir
+	case cpioTypeSymlink:
+		hdr.Typeflag = tar.TypeSymlink
+	case cpioTypeChar:
+		hdr.Typeflag = tar.TypeChar
+	case cpioTypeBlock:
+		hdr.Typeflag = tar.TypeBlock
+	case cpioTypeFifo:
+		hdr.Typeflag = tar.TypeFifo
+	case cpioTypeSocket:
+		hdr.Typeflag = cpioSocket
+	default:
+		if hdr.Name != cpioTrailer {
+			return nil, "", 0, fmt.Errorf("%s: unknown file type %o", hdr.Name, mode&cpioTypeMask)
+		}
+	}
+
+	c.remain = size
+	c.pad = padding(size, align)
+	return hdr, ino, int(nlink), nil
+}
+
+// padding returns the number of bytes needed to bring n up to a multiple
+// of align.
+func padding(n, align int64) int64 {
+	return (align - n%align) % align
+}
+
+// unexpected turns an EOF in the middle of the archive into
+// io.ErrUnexpectedEOF.
+func unexpected(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
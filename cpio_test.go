@@ -0,0 +1,116 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+// cpioEntry is an entry for cpioData.
+type cpioEntry struct {
+	name  string
+	mode  int64
+	ino   int64
+	nlink int64
+	data  string
+}
+
+// cpioData returns a "new" format cpio archive of the entries, or an odc
+// one, with the trailer unless truncated.
+func cpioData(odc bool, entries ...cpioEntry) []byte {
+	var buf bytes.Buffer
+	for _, e := range append(entries, cpioEntry{name: cpioTrailer, nlink: 1}) {
+		if odc {
+			fmt.Fprintf(&buf, "070707%06o%06o%06o%06o%06o%06o%06o%011o%06o%011o", 1, e.ino, e.mode, 0, 0, e.nlink, 0, 1234567890, len(e.name)+1, len(e.data))
+			buf.WriteString(e.name + "\x00" + e.data)
+			continue
+		}
+		fmt.Fprintf(&buf, "070701%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x", e.ino, e.mode, 0, 0, e.nlink, 1234567890, len(e.data), 0, 0, 0, 0, len(e.name)+1, 0)
+		buf.WriteString(e.name + "\x00")
+		buf.Write(make([]byte, padding(int64(110+len(e.name)+1), 4)))
+		buf.WriteString(e.data)
+		buf.Write(make([]byte, padding(int64(len(e.data)), 4)))
+	}
+	return buf.Bytes()
+}
+
+func TestCpioReader(t *testing.T) {
+	entries := []cpioEntry{
+		{name: "dir", mode: cpioTypeDir | 0755, ino: 1, nlink: 2},
+		{name: "dir/file", mode: cpioTypeReg | 0644, ino: 2, nlink: 1, data: "hello"},
+		{name: "dir/link", mode: cpioTypeSymlink | 0777, ino: 3, nlink: 1, data: "file"},
+		{name: "sock", mode: cpioTypeSocket | 0755, ino: 4, nlink: 1},
+	}
+	want := "dir/ dir/file=hello dir/link->file"
+	// The new format stores hard linked data with the last name only.
+	newcLinks := []cpioEntry{
+		{name: "a", mode: cpioTypeReg | 0644, ino: 5, nlink: 2},
+		{name: "b", mode: cpioTypeReg | 0644, ino: 5, nlink: 2, data: "shared"},
+	}
+	// The odc format with every name.
+	odcLinks := []cpioEntry{
+		{name: "a", mode: cpioTypeReg | 0644, ino: 5, nlink: 2, data: "shared"},
+		{name: "b", mode: cpioTypeReg | 0644, ino: 5, nlink: 2, data: "shared"},
+	}
+	good := cpioData(false, entries...)
+
+	cases := []struct {
+		name    string
+		data    []byte
+		want    string
+		wantErr string
+	}{
+		{"newc", good, want, ""},
+		{"odc", cpioData(true, entries...), want, ""},
+		{"newc hard links", cpioData(false, newcLinks...), "b=shared a=>b", ""},
+		{"odc hard links", cpioData(true, odcLinks...), "a=shared b=>a", ""},
+		{"truncated", good[:len(good)-150], "", "unexpected EOF"},
+		{"bad magic", []byte("070799" + strings.Repeat("0", 200)), "", "invalid header magic"},
+		{"bad field", []byte("070701" + strings.Repeat("x", 104)), "", "invalid header field"},
+		{"long symlink", cpioData(false, cpioEntry{name: "l", mode: cpioTypeSymlink | 0777, nlink: 1, data: strings.Repeat("x", cpioMaxLink+1)}), "", "invalid symlink target length"},
+		{"huge name", []byte("070701" + strings.Repeat("00000000", 11) + "00100000" + "00000000"), "", "invalid name length 1048576"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cr := newCpioReader(bytes.NewReader(tc.data))
+			var got []string
+			var err error
+			for {
+				var hdr *tar.Header
+				if hdr, err = cr.Next(); err != nil {
+					break
+				}
+				desc := hdr.Name
+				switch hdr.Typeflag {
+				case tar.TypeDir:
+					desc += "/"
+				case tar.TypeSymlink:
+					desc += "->" + hdr.Linkname
+				case tar.TypeLink:
+					desc += "=>" + hdr.Linkname
+				default:
+					data, _ := io.ReadAll(cr)
+					desc += "=" + string(data)
+				}
+				got = append(got, desc)
+			}
+			if err == io.EOF {
+				err = nil
+			}
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("error %v, expected %q", err, tc.wantErr)
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(got, " ") != tc.want {
+				t.Errorf("read %q, expected %q", strings.Join(got, " "), tc.want)
+			}
+		})
+	}
+}
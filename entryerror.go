@@ -0,0 +1,100 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+)
+
+// verboseErrors, for -verbose-errors, adds where in the archive an entry
+// failed to its error, so that the entry can be found with other tools.
+var verboseErrors = false
+
+// archiveFormat is the format the archive being extracted was taken to be,
+// sniffed or given by -format.
+var archiveFormat = ""
+
+// entryError is the failure of a single archive entry, with its position in
+// the archive.
+type entryError struct {
+	index  int
+	offset int64  // of the entry's data in the decompressed archive, or -1
+	typ    string // tar type flag or zip file type
+	format string
+	err    error
+}
+
+func (e *entryError) Error() string {
+	if !verboseErrors {
+		return e.err.Error()
+	}
+	pos := ""
+	if e.offset >= 0 {
+		pos = fmt.Sprintf(", data at offset %d", e.offset)
+	}
+	return fmt.Sprintf("%v (entry %d, type %s%s, format %s)", e.err, e.index, e.typ, pos, e.format)
+}
+
+func (e *entryError) Unwrap() error {
+	return e.err
+}
+
+// tarEntryError returns err, if any, as the failure of the tar entry with
+// the given index and header, the data of which is at offset.
+func tarEntryError(err error, index int, header *tar.Header, offset int64) error {
+	if err == nil {
+		return nil
+	}
+	return &entryError{index: index, offset: offset, typ: fmt.Sprintf("%q", header.Typeflag), format: archiveFormat, err: err}
+}
+
+// zipEntryError returns err, if any, as the failure of the zip entry zf with
+// the given index.
+func zipEntryError(err error, index int, zf *zip.File) error {
+	if err == nil {
+		return nil
+	}
+	offset, oerr := zf.DataOffset()
+	if oerr != nil {
+		offset = -1
+	}
+	typ := "file"
+	switch mode := zf.Mode(); {
+	case mode.IsDir():
+		typ = "dir"
+	case mode&os.ModeSymlink != 0:
+		typ = "symlink"
+	}
+	return &entryError{index: index, offset: offset, typ: typ, format: archiveFormat, err: err}
+}
+
+// tarOffsetReader is a tar.Reader that knows the offset of the current
+// entry's data, which is where reading the underlying stream has got to
+// after Next.
+type tarOffsetReader struct {
+	*tar.Reader
+	pos *offsetReader
+}
+
+func newTarOffsetReader(r io.Reader) *tarOffsetReader {
+	pos := &offsetReader{r: r}
+	return &tarOffsetReader{tar.NewReader(pos), pos}
+}
+
+func (r *tarOffsetReader) offset() int64 {
+	return r.pos.n
+}
+
+// offsetReader keeps track of how far into r reading has got.
+type offsetReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *offsetReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
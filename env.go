@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+// envFlags are the flags that fall back to an environment variable when
+// not given on the command line, along with any aliases of them. Secrets
+// such as the token are then kept out of process listings. The variable
+// also gives way to other flags that would conflict with it.
+var envFlags = []struct {
+	env     string
+	flag    string
+	aliases []string
+	unless  []string
+}{
+	{"DL_TOKEN", "token", nil, nil},
+	{"DL_STRIP", "strip", []string{"strip-components"}, nil},
+	{"DL_DESTINATION", "destination", []string{"directory"}, nil},
+	{"DL_SHA256", "sha256", nil, []string{"checksum"}},
+}
+
+// applyEnv sets the flags in envFlags from the environment, unless they
+// were given on the command line, so that a flag takes precedence over
+// the environment, which takes precedence over the default. It returns
+// the names of the flags it set.
+func applyEnv() (map[string]bool, error) {
+	given := make(map[string]bool)
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+
+next:
+	for _, ef := range envFlags {
+		val, ok := os.LookupEnv(ef.env)
+		if !ok || val == "" {
+			continue
+		}
+		for _, name := range append(append([]string{ef.flag}, ef.aliases...), ef.unless...) {
+			if given[name] {
+				continue next
+			}
+		}
+		if err := flag.Set(ef.flag, val); err != nil {
+			return nil, fmt.Errorf("$%s: %v", ef.env, err)
+		}
+		set[ef.flag] = true
+		if verbose && ef.env != "DL_TOKEN" {
+			fmt.Printf("Using -%s=%s from $%s\n", ef.flag, val, ef.env)
+		}
+	}
+	return set, nil
+}
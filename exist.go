@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// onExist choices for what to do when the destination already exists.
+const (
+	existOverwrite = "overwrite"
+	existMerge     = "merge"
+	existAbort     = "abort"
+	existPrompt    = "prompt"
+)
+
+var existChoices = map[string]bool{
+	existOverwrite: true,
+	existMerge:     true,
+	existAbort:     true,
+	existPrompt:    true,
+}
+
+// askOnExist asks on the terminal what to do about the existing
+// destination dst, returning one of the choices other than prompt.
+func askOnExist(dst string) (string, error) {
+	if !isTerminal(os.Stdin) || !isTerminal(os.Stderr) {
+		return "", fmt.Errorf("%s exists, and there's no terminal to ask what to do about it", dst)
+	}
+	in := bufio.NewReader(os.Stdin)
+	for {
+		fmt.Fprintf(os.Stderr, "%s exists. [o]verwrite, [m]erge or [a]bort? ", dst)
+		line, err := in.ReadString('\n')
+		if err != nil {
+			return "", errors.New("no answer")
+		}
+		switch strings.ToLower(strings.TrimSpace(line)) {
+		case "o", "overwrite":
+			return existOverwrite, nil
+		case "m", "merge":
+			return existMerge, nil
+		case "a", "abort", "":
+			return existAbort, nil
+		}
+	}
+}
+
+// mergeInto moves everything in src into dst, replacing what's already
+// there where the names collide, except that directories are merged
+// recursively. src is removed afterwards.
+func mergeInto(src, dst string) error {
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		from, to := filepath.Join(src, e.Name()), filepath.Join(dst, e.Name())
+		if fi, err := os.Lstat(to); err == nil {
+			if e.IsDir() && fi.IsDir() {
+				if err := mergeInto(from, to); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := os.RemoveAll(to); err != nil {
+				return err
+			}
+		}
+		if err := os.Rename(from, to); err != nil {
+			return err
+		}
+	}
+	return os.Remove(src)
+}
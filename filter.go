@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// includes and excludes are the -include and -exclude patterns. When
+// foldCase is set they match regardless of case.
+var (
+	includes = &patternList{}
+	excludes = &patternList{}
+	foldCase = false
+
+	// preserveDirs keeps the directory entries that -include or
+	// -only-files would otherwise leave out.
+	preserveDirs = false
+)
+
+// docPatterns are what -docs-only includes.
+var docPatterns = []string{"README*", "LICENSE*", "CHANGELOG*", "*.md"}
+
+// patternList is a repeatable flag of path.Match patterns. A pattern with
+// a slash matches the whole entry name, one without matches the last
+// component of it, at any depth.
+type patternList []string
+
+func (l *patternList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *patternList) Set(v string) error {
+	if _, err := path.Match(v, ""); err != nil {
+		return err
+	}
+	*l = append(*l, v)
+	return nil
+}
+
+func (l *patternList) match(name string) bool {
+	name = strings.TrimSuffix(path.Clean(filepath.ToSlash(name)), "/")
+	for _, pat := range *l {
+		subject := name
+		if !strings.Contains(pat, "/") {
+			subject = path.Base(name)
+		}
+		if foldCase {
+			pat, subject = strings.ToLower(pat), strings.ToLower(subject)
+		}
+		if ok, _ := path.Match(pat, subject); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// matchParents returns true if name, or any of the directories it's in,
+// matches the list.
+func (l *patternList) matchParents(name string) bool {
+	for name = path.Clean(filepath.ToSlash(name)); name != "." && name != "/"; name = path.Dir(name) {
+		if l.match(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// filtered returns the reason an entry is left out by -only-files,
+// -only-dirs, -include or -exclude, or an empty string if it isn't.
+// Excluding a directory excludes everything in it. With -include,
+// directories are created only as needed for the files that match, unless
+// -preserve-empty-dirs is given.
+//
+// The directory containing a file left out by -only-dirs is still created
+// when extracting, since the archive may not have an entry of its own for
+// it.
+func filtered(destination, name string, isDir bool) (string, error) {
+	switch {
+	case len(*excludes) > 0 && excludes.matchParents(name):
+		return "exclude", nil
+	case preserveDirs && isDir:
+		return "", nil
+	case len(*includes) > 0 && (isDir || !includes.match(name)):
+		return "include", nil
+	case onlyFiles && isDir:
+		return "type", nil
+	case onlyDirs && !isDir:
+		if listEntry != nil || tarOut != nil || dryRun {
+			return "type", nil
+		}
+		return "type", mkdirAll(filepath.Join(destination, filepath.Dir(name)))
+	default:
+		return "", nil
+	}
+}
+
+// skipped tracks the entries left out of the extraction, and why.
+var skipped = &skipTracker{}
+
+type skipTracker struct {
+	mut     sync.Mutex
+	names   []string
+	reasons []string
+}
+
+func (t *skipTracker) add(name, reason string) {
+	t.mut.Lock()
+	t.names = append(t.names, name)
+	t.reasons = append(t.reasons, reason)
+	t.mut.Unlock()
+}
+
+func (t *skipTracker) reset() {
+	t.mut.Lock()
+	t.names, t.reasons = nil, nil
+	t.mut.Unlock()
+}
+
+// report prints, in verbose mode, the skipped entries and a summary by
+// reason, such as "Skipped 120 entries: 118 by strip, 2 by exclude".
+func (t *skipTracker) report() {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	if !verbose || len(t.names) == 0 {
+		return
+	}
+
+	counts := make(map[string]int)
+	var order []string
+	for i, name := range t.names {
+		fmt.Printf(" - skipped (%s): %s\n", t.reasons[i], name)
+		if counts[t.reasons[i]] == 0 {
+			order = append(order, t.reasons[i])
+		}
+		counts[t.reasons[i]]++
+	}
+	parts := make([]string, len(order))
+	for i, reason := range order {
+		parts[i] = fmt.Sprintf("%d by %s", counts[reason], reason)
+	}
+	fmt.Printf("Skipped %d entries: %s\n", len(t.names), strings.Join(parts, ", "))
+}
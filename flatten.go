@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// flattenSingleDir moves the contents of the only entry of dir up into
+// dir, when that entry is a directory. Anything else is left as is.
+func flattenSingleDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	if len(entries) != 1 || !entries[0].IsDir() {
+		return nil
+	}
+
+	// The wrapper is moved aside first, as it may well contain an entry
+	// of the same name.
+	wrapper := filepath.Join(dir, entries[0].Name())
+	aside, err := os.MkdirTemp(dir, ".flatten-*")
+	if err != nil {
+		return err
+	}
+	moved := filepath.Join(aside, "dir")
+	if err := os.Rename(wrapper, moved); err != nil {
+		return err
+	}
+
+	children, err := os.ReadDir(moved)
+	if err != nil {
+		return err
+	}
+	for _, child := range children {
+		if err := os.Rename(filepath.Join(moved, child.Name()), filepath.Join(dir, child.Name())); err != nil {
+			return fmt.Errorf("%s: moving up: %v", child.Name(), err)
+		}
+	}
+	if verbose {
+		fmt.Printf("Flattened the single directory %s/\n", entries[0].Name())
+	}
+	return os.RemoveAll(aside)
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"path"
+	"strings"
+)
+
+// Archive and compression formats are recognized by the magic bytes at the
+// start of the stream, without consuming them, or a valid tar header, with
+// the Content-Type of the response and then the extension of the URL as
+// fallbacks.
+
+var (
+	magicZip      = []byte("PK\x03\x04")
+	magicEmptyZip = []byte("PK\x05\x06")
+	magicGzip     = []byte{0x1f, 0x8b}
+	magicLzip     = []byte("LZIP")
+	magicBzip2    = []byte("BZh")
+	magicXz       = []byte("\xfd7zXZ\x00")
+	magicPE       = []byte("MZ")
+)
+
+// formats are the names accepted by -format.
+var formats = map[string]bool{
+	"zip":     true,
+	"tar":     true,
+	"tar.gz":  true,
+	"tgz":     true,
+	"tar.bz2": true,
+	"tar.lz":  true,
+	"tar.zst": true,
+	"cpio":    true,
+	"cpio.gz": true,
+}
+
+// errUnsupportedCompression is returned for compression formats that are
+// recognized but can't be decompressed.
+var errUnsupportedCompression = errors.New("compression format not supported")
+
+// forceFormat is the -format to use instead of detecting it.
+var forceFormat = ""
+
+func hasMagic(br *bufio.Reader, magic []byte) bool {
+	head, _ := br.Peek(len(magic))
+	return bytes.Equal(head, magic)
+}
+
+func isZip(br *bufio.Reader) bool {
+	return hasMagic(br, magicZip) || hasMagic(br, magicEmptyZip)
+}
+
+// zipStart returns the offset of the zip archive in data, which is at the
+// end of it but may be preceded by something else, such as the executable
+// of a self-extracting archive. The end of central directory record says
+// how large the directory is and where it should be relative to the start
+// of the archive, which tells us where that start actually is. It returns
+// -1 if there's no zip archive in data.
+func zipStart(data []byte) int64 {
+	// The record is 22 bytes plus a comment of up to 64 KiB.
+	const recordLen = 22
+	tail := data
+	if len(tail) > recordLen+65535 {
+		tail = tail[len(tail)-recordLen-65535:]
+	}
+	i := bytes.LastIndex(tail, magicEmptyZip)
+	if i < 0 || len(tail)-i < recordLen {
+		return -1
+	}
+	record := tail[i:]
+	eocd := int64(len(data) - len(tail) + i)
+	dirSize := int64(binary.LittleEndian.Uint32(record[12:]))
+	dirOffset := int64(binary.LittleEndian.Uint32(record[16:]))
+	if dirOffset == 0xffffffff {
+		// Zip64 keeps the real values elsewhere; leave it to
+		// archive/zip.
+		return 0
+	}
+	if start := eocd - dirSize - dirOffset; start > 0 {
+		return start
+	}
+	return 0
+}
+
+// detectFormat returns the format of the archive about to be read from br,
+// which was served with the Content-Type ctype. That's "exe" for what may be
+// a self-extracting archive, an executable with a zip appended, which takes
+// reading all of it to tell; see sniffFormat for what it may be otherwise.
+func detectFormat(url, ctype string, br *bufio.Reader) string {
+	if hasMagic(br, magicPE) && !isTar(br) {
+		return "exe"
+	}
+	return sniffFormat(url, ctype, br)
+}
+
+// sniffFormat returns the format of the archive about to be read from br
+// like detectFormat, other than for executables.
+func sniffFormat(url, ctype string, br *bufio.Reader) string {
+	switch {
+	case isZip(br):
+		return "zip"
+	case hasMagic(br, magicGzip):
+		return "tar.gz"
+	case hasMagic(br, magicBzip2):
+		return "tar.bz2"
+	case hasMagic(br, magicXz):
+		return "tar.xz"
+	case hasMagic(br, magicLzip):
+		return "tar.lz"
+	case isZstd(br):
+		return "tar.zst"
+	case isCpio(br):
+		return "cpio"
+	}
+	if isTar(br) {
+		// Such as a .tar.gz the transport already decompressed for a
+		// Content-Encoding: gzip, whatever the extension or the
+		// Content-Type say.
+		return "tar"
+	}
+	if mediaType, _, err := mime.ParseMediaType(ctype); err == nil {
+		if format, ok := mimeFormats[mediaType]; ok {
+			return format
+		}
+	}
+	if format, ok := extFormats[strings.ToLower(path.Ext(urlFileName(url)))]; ok {
+		return format
+	}
+	return "tar"
+}
+
+// mimeFormats are the formats implied by the Content-Type of the response,
+// for servers that are honest about what they serve. Generic types such as
+// application/octet-stream don't tell and are left to the extension.
+var mimeFormats = map[string]string{
+	"application/zip":              "zip",
+	"application/x-zip-compressed": "zip",
+	"application/gzip":             "tar.gz",
+	"application/x-gzip":           "tar.gz",
+	"application/x-compressed-tar": "tar.gz",
+	"application/x-bzip2":          "tar.bz2",
+	"application/x-bzip":           "tar.bz2",
+	"application/x-xz":             "tar.xz",
+	"application/x-lzip":           "tar.lz",
+	"application/zstd":             "tar.zst",
+	"application/x-zstd":           "tar.zst",
+	"application/x-tar":            "tar",
+	"application/x-cpio":           "cpio",
+}
+
+// extFormats are the formats implied by file extensions, including the
+// shorthands for compressed tar files, for when the magic bytes don't
+// tell.
+var extFormats = map[string]string{
+	".zip":  "zip",
+	".gz":   "tar.gz",
+	".tgz":  "tar.gz",
+	".bz2":  "tar.bz2",
+	".tbz":  "tar.bz2",
+	".tbz2": "tar.bz2",
+	".xz":   "tar.xz",
+	".txz":  "tar.xz",
+	".lz":   "tar.lz",
+	".zst":  "tar.zst",
+	".tzst": "tar.zst",
+}
+
+// decompressingReader returns a reader for the decompressed contents of br,
+// which is compressed according to the tar or cpio format.
+func decompressingReader(br *bufio.Reader, format string) (io.Reader, error) {
+	switch format {
+	case "tar.gz", "tgz", "cpio.gz":
+		return gzip.NewReader(br)
+	case "tar", "cpio":
+		return br, nil
+	case "tar.bz2":
+		return bzip2.NewReader(br), nil
+	case "tar.lz":
+		return lzipReader(br)
+	case "tar.xz":
+		// There's no xz (LZMA2) decoder in the standard library.
+		return nil, fmt.Errorf("xz: %w", errUnsupportedCompression)
+	case "tar.zst":
+		return zstdReader(br)
+	default:
+		return nil, fmt.Errorf("unsupported format %q", format)
+	}
+}
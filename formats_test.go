@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestSelfExtracting(t *testing.T) {
+	zipped := zipData(t, "app/", "", "app/readme.txt", "hello")
+	stub := append([]byte("MZ\x90\x00"), bytes.Repeat([]byte{0xcc}, 5000)...)
+
+	cases := []struct {
+		name    string
+		data    []byte
+		start   int64
+		wantErr string
+	}{
+		{"plain zip", zipped, 0, ""},
+		{"executable with zip", append(append([]byte(nil), stub...), zipped...), int64(len(stub)), ""},
+		{"executable only", stub, -1, "executable without an appended zip archive"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if start := zipStart(tc.data); start != tc.start {
+				t.Errorf("zip starts at %d, expected %d", start, tc.start)
+			}
+			dst, err := extractData(t, tc.data, 0)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("error %v, expected %q", err, tc.wantErr)
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.Join(listFiles(t, dst), " "); got != "app/ app/readme.txt" {
+				t.Errorf("extracted %q", got)
+			}
+		})
+	}
+}
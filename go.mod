@@ -0,0 +1,10 @@
+module github.com/calmh/dl
+
+go 1.25
+
+require (
+	github.com/klauspost/compress v1.20.1
+	github.com/sorairolake/lzip-go v0.3.8
+)
+
+require github.com/ulikunitz/xz v0.5.13 // indirect
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// extractJournal, when set, records how far the extraction of a tar or
+// cpio archive has got, for -continue-partial-extract. An interrupted run
+// of the same cached archive resumes after the last recorded entry.
+var extractJournal *journal
+
+// journalInterval is how often the journal is synced to disk. A crash
+// loses at most the entries extracted since, which are then redone.
+const journalInterval = time.Second
+
+type journal struct {
+	path   string
+	source string // the URL the entries are from
+	done   int    // entries fully extracted
+	synced time.Time
+}
+
+// openJournal reads the journal at path, if there is one for source.
+func openJournal(path, source string) (*journal, error) {
+	j := &journal{path: path, source: source, synced: time.Now()}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return j, nil
+	} else if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 || lines[0] != source {
+		// Some other archive's, which doesn't apply.
+		return j, nil
+	}
+	if j.done, err = strconv.Atoi(lines[1]); err != nil || j.done < 0 {
+		return nil, fmt.Errorf("%s: invalid journal", path)
+	}
+	return j, nil
+}
+
+// advance records that the first done entries are extracted, syncing the
+// journal when it's been a while.
+func (j *journal) advance(done int) error {
+	j.done = done
+	if time.Since(j.synced) < journalInterval {
+		return nil
+	}
+	return j.sync()
+}
+
+// sync writes the journal out and makes sure it's on disk.
+func (j *journal) sync() error {
+	tmp := j.path + ".new"
+	fd, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("writing journal: %v", err)
+	}
+	_, err = fmt.Fprintf(fd, "%s\n%d\n", j.source, j.done)
+	if err == nil {
+		err = fd.Sync()
+	}
+	if cerr := fd.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, j.path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("writing journal: %v", err)
+	}
+	j.synced = time.Now()
+	return nil
+}
+
+// reset starts over from the first entry.
+func (j *journal) reset() {
+	j.done = 0
+	os.Remove(j.path)
+}
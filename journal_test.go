@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOpenJournal(t *testing.T) {
+	const src = "https://example.com/a.tar.gz"
+	cases := []struct {
+		name     string
+		contents string // or none at all, if empty
+		done     int
+		wantErr  bool
+	}{
+		{"no journal", "", 0, false},
+		{"resumable", src + "\n42\n", 42, false},
+		{"other archive", "https://example.com/b.tar.gz\n42\n", 0, false},
+		{"garbage count", src + "\nmany\n", 0, true},
+		{"negative count", src + "\n-1\n", 0, true},
+		{"truncated", src, 0, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "journal")
+			if tc.contents != "" {
+				if err := os.WriteFile(path, []byte(tc.contents), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			j, err := openJournal(path, src)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("error %v, expected error %v", err, tc.wantErr)
+			}
+			if err == nil && j.done != tc.done {
+				t.Errorf("done %d, expected %d", j.done, tc.done)
+			}
+		})
+	}
+}
+
+func TestJournalResume(t *testing.T) {
+	data := tarData(t,
+		tarEntry{name: "a", body: "a"},
+		tarEntry{name: "b", body: "b"},
+		tarEntry{name: "../c", body: "c"},
+		tarEntry{name: "d", body: "d"},
+	)
+	cases := []struct {
+		name      string
+		resume    int
+		keepGoing bool
+		want      string
+		done      int
+	}{
+		// Entries before the resume point are taken to be there
+		// already, and the bad third entry stops the extraction.
+		{"from the start", 0, false, "a b", 2},
+		{"after the first", 1, false, "b", 2},
+		// Past the failure nothing is recorded, so that it's redone.
+		{"keep going", 0, true, "a b d", 2},
+		{"after the bad entry", 3, false, "d", 4},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			src := "https://example.com/a.tar"
+			j := &journal{path: filepath.Join(dir, "journal"), source: src, done: tc.resume}
+			extractJournal, keepGoing = j, tc.keepGoing
+			defer func() {
+				extractJournal, keepGoing = nil, false
+				skipped.reset()
+				overwritten.reset()
+			}()
+
+			dst := filepath.Join(dir, "dst")
+			untar(context.Background(), bytes.NewReader(data), dst, 0)
+			var got []string
+			if _, err := os.Stat(dst); err == nil {
+				got = listFiles(t, dst)
+			}
+			if strings.Join(got, " ") != tc.want {
+				t.Errorf("extracted %q, expected %q", strings.Join(got, " "), tc.want)
+			}
+			// What was recorded should be what a rerun picks up.
+			reopened, err := openJournal(j.path, src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reopened.done != tc.done {
+				t.Errorf("journal has %d entries done, expected %d", reopened.done, tc.done)
+			}
+		})
+	}
+}
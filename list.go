@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+// entryInfo describes an archive entry as it would be extracted, that is
+// with the name after stripping.
+type entryInfo struct {
+	Index    int
+	Name     string
+	Size     int64
+	Mode     os.FileMode
+	ModTime  time.Time
+	Linkname string
+	Comment  string // of zip entries
+}
+
+// listEntry, when set, is called for each selected entry instead of it
+// being extracted.
+var listEntry func(entryInfo)
+
+// list downloads the archive at url and calls fn for each entry, after
+// stripping, without writing anything to disk. Entries are reported as
+// they are read, so tar archives are never held in memory.
+func list(ctx context.Context, url string, strip int, fn func(entryInfo)) error {
+	listEntry = fn
+	defer func() { listEntry = nil }()
+	return download(ctx, url, "", strip)
+}
+
+// listFormatter writes the entries of a listing in some format.
+type listFormatter interface {
+	entry(e entryInfo)
+	// flush finishes the listing once all entries have been written.
+	flush() error
+}
+
+// listFormats are the -list-format choices.
+var listFormats = map[string]func(w io.Writer) listFormatter{
+	"table": func(w io.Writer) listFormatter { return tableLister{w} },
+	"json":  func(w io.Writer) listFormatter { return &jsonLister{w: w} },
+	"csv":   func(w io.Writer) listFormatter { return &csvLister{w: csv.NewWriter(w)} },
+}
+
+// tableLister prints one entry per line in aligned columns.
+type tableLister struct {
+	w io.Writer
+}
+
+func (l tableLister) entry(e entryInfo) {
+	name := e.Name
+	if e.Linkname != "" {
+		name += " -> " + e.Linkname
+	}
+	if e.Comment != "" {
+		name += "  # " + e.Comment
+	}
+	fmt.Fprintf(l.w, "%5d %v %10d %s %s\n", e.Index, e.Mode, e.Size, e.ModTime.Format("2006-01-02 15:04"), name)
+}
+
+func (l tableLister) flush() error {
+	return nil
+}
+
+// jsonLister prints a JSON array of entries.
+type jsonLister struct {
+	w       io.Writer
+	entries int
+}
+
+type jsonEntry struct {
+	Index    int       `json:"index"`
+	Name     string    `json:"name"`
+	Size     int64     `json:"size"`
+	Mode     string    `json:"mode"`
+	ModTime  time.Time `json:"mtime"`
+	Linkname string    `json:"link,omitempty"`
+	Comment  string    `json:"comment,omitempty"`
+}
+
+func (l *jsonLister) entry(e entryInfo) {
+	// Entries are written as they come rather than collected, to keep
+	// the streaming of tar archives.
+	sep := ",\n  "
+	if l.entries == 0 {
+		sep = "[\n  "
+	}
+	l.entries++
+	bs, _ := json.Marshal(jsonEntry{e.Index, e.Name, e.Size, e.Mode.String(), e.ModTime.UTC(), e.Linkname, e.Comment})
+	fmt.Fprintf(l.w, "%s%s", sep, bs)
+}
+
+func (l *jsonLister) flush() error {
+	if l.entries == 0 {
+		_, err := fmt.Fprintln(l.w, "[]")
+		return err
+	}
+	_, err := fmt.Fprintln(l.w, "\n]")
+	return err
+}
+
+// csvLister prints entries as CSV, with a header line.
+type csvLister struct {
+	w      *csv.Writer
+	header bool
+}
+
+func (l *csvLister) entry(e entryInfo) {
+	l.writeHeader()
+	l.w.Write([]string{strconv.Itoa(e.Index), e.Name, strconv.FormatInt(e.Size, 10), e.Mode.String(), e.ModTime.UTC().Format(time.RFC3339), e.Linkname, e.Comment})
+}
+
+func (l *csvLister) flush() error {
+	l.writeHeader()
+	l.w.Flush()
+	return l.w.Error()
+}
+
+func (l *csvLister) writeHeader() {
+	if !l.header {
+		l.w.Write([]string{"index", "name", "size", "mode", "mtime", "link", "comment"})
+		l.header = true
+	}
+}
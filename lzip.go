@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+
+	"github.com/sorairolake/lzip-go"
+)
+
+// An lzip member is a six byte header, the LZMA stream and a trailer with
+// the CRC-32 and size of the data and the size of the member.
+const (
+	lzipHeaderLen  = 6
+	lzipTrailerLen = 20
+)
+
+// lzipReader returns a reader for the decompressed contents of the lzip
+// file read from r. The decoder works on the whole member in memory, and
+// doesn't check the trailer, so that's done here; files of several members
+// (as from plzip) aren't supported.
+func lzipReader(r io.Reader) (io.Reader, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < lzipHeaderLen+lzipTrailerLen {
+		return nil, errors.New("lzip: truncated")
+	}
+	trailer := data[len(data)-lzipTrailerLen:]
+	if size := binary.LittleEndian.Uint64(trailer[12:]); size != uint64(len(data)) {
+		return nil, fmt.Errorf("lzip: member size %d isn't the file size %d (multi-member files are not supported)", size, len(data))
+	}
+	zr, err := lzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("lzip: %v", err)
+	}
+	return &lzipCheckReader{
+		r:    zr,
+		crc:  crc32.NewIEEE(),
+		sum:  binary.LittleEndian.Uint32(trailer),
+		size: binary.LittleEndian.Uint64(trailer[4:]),
+	}, nil
+}
+
+// lzipCheckReader checks the data read from r against the CRC-32 and size
+// in the lzip trailer once it's all been read.
+type lzipCheckReader struct {
+	r    io.Reader
+	crc  hash.Hash32
+	sum  uint32
+	size uint64
+	n    uint64
+}
+
+func (r *lzipCheckReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.crc.Write(p[:n])
+	r.n += uint64(n)
+	if err == io.EOF {
+		if r.n != r.size {
+			return n, fmt.Errorf("lzip: data size %d, expected %d", r.n, r.size)
+		}
+		if sum := r.crc.Sum32(); sum != r.sum {
+			return n, fmt.Errorf("lzip: CRC-32 %08x, expected %08x", sum, r.sum)
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/sorairolake/lzip-go"
+)
+
+func lzipData(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := lzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestLzipRoundTrip(t *testing.T) {
+	var tbuf bytes.Buffer
+	tw := tar.NewWriter(&tbuf)
+	content := bytes.Repeat([]byte("hello lzip\n"), 1000)
+	if err := tw.WriteHeader(&tar.Header{Name: "dir/file.txt", Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+		t.Fatal(err)
+	}
+	tw.Write(content)
+	tw.Close()
+	good := lzipData(t, tbuf.Bytes())
+
+	badCRC := append([]byte(nil), good...)
+	badCRC[len(badCRC)-lzipTrailerLen] ^= 0xff
+	twice := append(append([]byte(nil), good...), good...)
+
+	cases := []struct {
+		name    string
+		data    []byte
+		url     string
+		wantErr bool
+	}{
+		{"good", good, "https://example.com/x.tar.lz", false},
+		{"by magic only", good, "https://example.com/download", false},
+		{"bad crc", badCRC, "x.tar.lz", true},
+		{"truncated", good[:10], "x.tar.lz", true},
+		{"multi-member", twice, "x.tar.lz", true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			br := bufio.NewReader(bytes.NewReader(tc.data))
+			if format := sniffFormat(tc.url, "", br); format != "tar.lz" {
+				t.Fatalf("format %q, expected tar.lz", format)
+			}
+			r, err := decompressingReader(br, "tar.lz")
+			if err == nil {
+				tr := tar.NewReader(r)
+				var hdr *tar.Header
+				if hdr, err = tr.Next(); err == nil {
+					var got []byte
+					if got, err = ioutil.ReadAll(tr); err == nil {
+						if hdr.Name != "dir/file.txt" || !bytes.Equal(got, content) {
+							t.Errorf("got %q with %d bytes", hdr.Name, len(got))
+						}
+						// Reading to the end is what checks the trailer.
+						_, err = io.Copy(ioutil.Discard, r)
+					}
+				}
+			}
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Errorf("error %v, expected error %v", err, tc.wantErr)
+			}
+		})
+	}
+}
@@ -3,194 +3,1920 @@ package main
 import (
 	"archive/tar"
 	"archive/zip"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"hash"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"runtime"
+	"strconv"
+	"syscall"
+	"text/template"
+	"time"
+
+	"bytes"
+	"io/ioutil"
+	"path"
+	"strings"
+	"sync"
+)
+
+var (
+	verbose   = false
+	keepGoing = false
+	sanitize  = false
+	metadata  = false
+	atomic    = false
+	noExec    = false
+	normPerms = false
+	maxFiles  = 0
+	workers   = 1
+	xattrs    = false
+
+	// bufferSize is the size of the buffer used when writing out files;
+	// the default is the same as io.Copy's.
+	bufferSize = 32 << 10
+
+	// maxSize is the largest archive we're willing to download, or zero
+	// for no limit.
+	maxSize int64 = 0
+
+	zipPassword  = ""
+	cacheDir     = ""
+	skipExisting = false
+	noAbsLinks   = false
+	abortOnAbs   = false
+	dryRun       = false
+	onlyFiles    = false
+	onlyDirs     = false
+
+	// Unless keeping the exact archive permissions, umask is masked off
+	// file modes like tar does for non-root users.
+	samePerms = false
+	umask     os.FileMode
+
+	// clampTime is the latest modification time given to extracted
+	// files; when zero, files keep the time they were written.
+	clampTime time.Time
+)
+
+var errLocked = errors.New("locked by another process")
+
+// exitPartial is the exit status of a -partial-ok run that put an
+// incomplete destination in place.
+const exitPartial = 3
+
+func main() {
+	run()
+	failOnWarnings()
+}
+
+func run() {
+	// The GNU tar names are accepted as aliases. Both names set the same
+	// value, so if both are given the last one on the command line wins.
+	destination := flag.String("destination", "", "Destination to unpack into (default $DL_DESTINATION)")
+	flag.StringVar(destination, "directory", "", "Alias for -destination")
+	nameTemplate := flag.String("name-template", "", "Name the destination, when not given, from this text/template of {{.File}}, {{.Name}} (without archive extension), {{.Ext}} and {{.Version}}")
+	strip := flag.Int("strip", 0, "Strip path components from archive (default $DL_STRIP)")
+	flag.IntVar(strip, "strip-components", 0, "Alias for -strip")
+	prependDir := flag.String("prepend", "", "Put entries in this directory within the destination, after -strip and -rename-regex")
+	partialOK := flag.Bool("partial-ok", false, "Move destination into place even if some entries failed (with -keep-going), then exit with status 3")
+	flag.BoolVar(&verboseErrors, "verbose-errors", verboseErrors, "Give the index, type and offset of failing entries and the archive format in errors")
+	flag.BoolVar(&strict, "strict", strict, "Exit unsuccessfully if there were any warnings, listing them at the end")
+	flag.BoolVar(&strict, "fail-on-warnings", strict, "Alias for -strict")
+	flag.BoolVar(&keepGoing, "keep-going", keepGoing, "Continue extracting after a failed entry and report all failures at the end")
+	flag.BoolVar(&sanitize, "sanitize", sanitize, "Replace characters in entry names that are invalid on Windows instead of failing (Windows only)")
+	flag.DurationVar(&connectTimeout, "connect-timeout", connectTimeout, "Give up on connecting to a server, including the TLS handshake, after this long (0 for the default)")
+	timeLimit := flag.Duration("time-limit", 0, "Abort if download and extraction together take longer than this (0 for no limit)")
+	requireHTTPS := flag.Bool("require-https", false, "Refuse to download over plain http://")
+	flag.BoolVar(&metadata, "metadata", metadata, "Print archive metadata, such as zip comments, while extracting")
+	flag.BoolVar(&appInfo, "app-info", appInfo, "Print the manifest of APK, IPA and JAR packages (AndroidManifest.xml, Info.plist, META-INF/MANIFEST.MF) while extracting")
+	flag.BoolVar(&atomic, "atomic-per-file", atomic, "Write each file to a temporary and rename it into place, so it appears atomically (costs an extra rename per file)")
+	flag.StringVar(&requestMethod, "method", "", "HTTP method to request the archive with (default GET, or POST with -data)")
+	data := flag.String("data", "", "Send this as the request body, as JSON if it's valid JSON and as form data otherwise (implies -method POST)")
+	dataFile := flag.String("data-file", "", "Send the contents of this file as the request body, like -data")
+	zstdDictFile := flag.String("zstd-dict", "", "Decompress zstd archives compressed with a dictionary with the one in this file, as made by zstd --train")
+	onlyNewer := flag.Bool("only-newer", false, "Skip the download if the destination exists and the server reports an unchanged ETag/Last-Modified")
+	flag.BoolVar(&noExec, "no-exec", noExec, "Remove the executable bits from all extracted files")
+	destMode := flag.String("destination-mode", "", "Give the top level destination directory this octal mode, such as 0700, whatever the archive says")
+	flag.BoolVar(&normPerms, "normalize-permissions", normPerms, "Ignore the archive permissions beyond the executable bit: files get 0755 if executable and 0644 otherwise, without setuid, setgid or sticky bits")
+	flag.IntVar(&maxFiles, "max-files", maxFiles, "Fail if the archive has more than this many entries (0 for no limit)")
+	toTar := flag.String("to-tar", "", "Write the stripped archive entries as a tar file to this path (- for stdout) instead of extracting")
+	flag.Int64Var(&maxSize, "max-size", maxSize, "Refuse to download archives larger than this many bytes (0 for no limit)")
+	flag.IntVar(&bufferSize, "buffer-size", bufferSize, "Size in bytes of the buffer used when writing extracted files")
+	flag.IntVar(&workers, "extract-concurrency", workers, "Number of entries to extract in parallel (zip only; tar is inherently sequential)")
+	flag.BoolVar(&xattrs, "xattrs", xattrs, "Restore extended attributes from PAX tar headers (Linux only)")
+	listOnly := flag.Bool("list", false, "List the archive entries instead of extracting")
+	listFormat := flag.String("list-format", "table", "Output format for -list (table, json, csv)")
+	flag.StringVar(&zipPassword, "password", zipPassword, "Password for encrypted zip entries (ZipCrypto or AES)")
+	clampMtime := flag.Int64("clamp-mtime", -1, "Clamp modification times of extracted files to this Unix time (defaults to $SOURCE_DATE_EPOCH when set)")
+	flag.StringVar(&cacheDir, "cache", cacheDir, "Keep downloaded archives in this directory and reuse them instead of downloading again")
+	flag.BoolVar(&skipExisting, "skip-existing", skipExisting, "Don't rewrite files already present in the destination with the same size and modification time (for resuming an interrupted extraction)")
+	flag.StringVar(&tlsServerName, "tls-servername", tlsServerName, "Verify the server certificate against this name instead of the URL host")
+	tlsMin := flag.String("tls-min-version", "", "Refuse TLS versions older than this (1.2 or 1.3; default Go's minimum)")
+	proxy := flag.String("proxy", "", "Use this proxy, as http://, https:// or socks5://[user:pass@]host:port, instead of the one from $HTTPS_PROXY/$HTTP_PROXY")
+	ipv4 := flag.Bool("4", false, "Connect over IPv4 only")
+	ipv6 := flag.Bool("6", false, "Connect over IPv6 only")
+	flag.BoolVar(&abortOnAbs, "abort-on-absolute-path", abortOnAbs, "Fail on entries with absolute names (a leading slash or drive letter) instead of extracting them relative to the destination")
+	flag.BoolVar(&noAbsLinks, "no-absolute-symlinks", noAbsLinks, "Reject symlinks with absolute targets or targets outside the destination")
+	flag.IntVar(&retries, "retries", retries, "Retry failed requests this many times, with exponential backoff")
+	flag.BoolVar(&retryMismatch, "retry-on-checksum-mismatch", retryMismatch, "Download again on a checksum mismatch, within -retries, before failing")
+	flag.DurationVar(&retryMaxTime, "retry-max-time", retryMaxTime, "Stop retrying once this much time has been spent (0 for no limit)")
+	flag.Var(indices, "index", "Only extract the entry with this zero based index, as shown by -list (repeatable)")
+	charset := flag.String("charset", "", "Decode zip entry names not flagged as UTF-8 from this code page (cp437, cp850, windows-1252, iso-8859-1)")
+	flag.StringVar(&forceFormat, "format", forceFormat, "Archive format to use instead of detecting it (zip, tar, tar.gz, tar.bz2, tar.lz, tar.zst, cpio, cpio.gz)")
+	doneFile := flag.String("done-file", "", "Create this file once the destination has been fully extracted and moved into place")
+	flag.IntVar(&maxRedirects, "max-redirects", maxRedirects, "Follow at most this many redirects")
+	flag.BoolVar(&noRedirect, "no-redirect", noRedirect, "Fail on any redirect instead of following it")
+	flag.IntVar(&maxConnsPerHost, "per-host", maxConnsPerHost, "Maximum number of concurrent connections to any one host (0 for no limit)")
+	sha256sum := flag.String("sha256", "", "Verify the downloaded archive against this SHA-256 digest (default $DL_SHA256)")
+	checksumHex := flag.String("checksum", "", "Verify the downloaded archive against this digest, with the algorithm inferred from its length")
+	allowInsecureHashes := flag.Bool("allow-insecure-hashes", false, "Accept md5 and sha1 digests for verification, such as those of old releases")
+	chown := flag.String("chown", "", "Give all extracted files this owner, as user:group names or ids, instead of the current user")
+	flag.BoolVar(&samePerms, "same-permissions", os.Geteuid() == 0, "Give files the exact archive permissions instead of applying the umask (default when running as root)")
+	flag.StringVar(&remoteMember, "remote-member", remoteMember, "Extract only this entry of a remote zip, using range requests to avoid downloading the whole archive")
+	flag.Var(includes, "include", "Extract only files matching this pattern (repeatable; matches the base name unless the pattern has a slash)")
+	flag.Var(renames, "rename-regex", "Rename entries matching PATTERN by replacing the match with REPL, given as PATTERN=REPL (repeatable; applied after -strip)")
+	stripVersion := flag.Bool("strip-trailing-version", false, "Remove the @version from the leading path of Go module zips, as in example.com/mod@v1.2.3/")
+	flag.Var(excludes, "exclude", "Don't extract entries matching this pattern, or anything in directories matching it (repeatable)")
+	flag.BoolVar(&preserveDirs, "preserve-empty-dirs", preserveDirs, "Create all directory entries, even when -include or -only-files leave out everything in them (-exclude still applies)")
+	docsOnly := flag.Bool("docs-only", false, "Extract only documentation files (README*, LICENSE*, CHANGELOG*, *.md), ignoring case")
+	flag.BoolVar(&onlyFiles, "only-files", onlyFiles, "Extract only non-directory entries")
+	flag.BoolVar(&onlyDirs, "only-dirs", onlyDirs, "Extract only the directory tree, without any files")
+	flag.BoolVar(&dryRun, "dry-run", dryRun, "Check every entry as for extraction and print where it would go, without writing anything")
+	noWait := flag.Bool("no-wait", false, "Fail instead of waiting when the destination is locked")
+	versionedDir := flag.Bool("versioned-dir", false, "Extract into a subdirectory of the destination named after the version")
+	version := flag.String("version", "", "Version for -versioned-dir, instead of the one in the URL")
+	currentLink := flag.Bool("current-link", false, "With -versioned-dir, point a \"current\" symlink in the destination at the new version")
+	onExist := flag.String("on-exist", "", "What to do when the destination exists: overwrite, merge, abort or prompt (ask on the terminal); by default, fail to move the new one into place")
+	postCmd := flag.String("post-cmd", "", "Run this shell command in the destination once it's fully extracted and in place, with its path in $"+postCmdEnv+"; its exit status becomes ours")
+	downloadOnly := flag.Bool("download-only", false, "Save the archive itself to the destination (by default its file name from the URL) instead of extracting it")
+	flag.Int64Var(&skipBytes, "skip", skipBytes, "Skip this many bytes at the start of the download (a raw byte window, not entries)")
+	flag.Int64Var(&limitBytes, "limit", limitBytes, "Download only this many bytes, after any -skip (0 for no limit)")
+	flag.BoolVar(&verifyExtracted, "verify-extracted", verifyExtracted, "Read back every extracted file and check its size, and for zip archives its CRC-32, against the archive")
+	flag.StringVar(&hardlinkFallback, "hardlink-fallback", hardlinkFallback, "Set to \"copy\" to copy the target of hard links where the file system doesn't support them, instead of failing")
+	flatten := flag.Bool("flatten-single-dir", false, "If everything was extracted into a single top level directory, move its contents up into the destination")
+	continuePartial := flag.Bool("continue-partial-extract", false, "Keep a journal of extracted tar and cpio entries so that an interrupted extraction, rerun with the same -cache, resumes where it left off")
+	tempSuffix := flag.String("temp-suffix", ".tmp", "Suffix of the temporary directory extracted into, next to the destination")
+	keepTemp := flag.Bool("keep-temp", false, "Keep the temporary directory when failing, for inspection")
+	makeParents := flag.Bool("make-parents", true, "Create the parent directories of the destination if they don't exist")
+	flag.BoolVar(&printURL, "print-url-after-redirects", printURL, "Print the URL the archive is fetched from, after any redirects, on a line of its own")
+	progress := flag.Bool("progress", false, "Show download progress and throughput on stderr")
+	flag.StringVar(&bearerToken, "token", bearerToken, "Send this bearer token with requests (default $DL_TOKEN, which keeps it out of process listings)")
+	output := flag.String("o", "", "File to save the archive to, or - for stdout, for fetch and -download-only (default -destination, or the file name from the URL)")
+	flag.BoolVar(&verbose, "v", verbose, "Verbose output")
+	command, args := parseCommandLine()
+
+	fromEnv, err := applyEnv()
+	if err != nil {
+		fmt.Println("Environment:", err)
+		os.Exit(2)
+	}
+
+	switch {
+	case command == commandExtract && len(args) != 1:
+		fmt.Println("File to extract as only parameter")
+		os.Exit(2)
+	case len(args) != 1:
+		fmt.Println("URL (or - for stdin) as only parameter")
+		os.Exit(2)
+	}
+	switch command {
+	case commandFetch:
+		*downloadOnly = true
+	case commandExtract:
+		if cacheDir != "" || remoteMember != "" || *onlyNewer {
+			fmt.Println("-cache, -remote-member and -only-newer don't apply to local files")
+			os.Exit(2)
+		}
+		localSource = true
+	}
+
+	switch {
+	case *ipv4 && *ipv6:
+		fmt.Println("-4 and -6 are mutually exclusive")
+		os.Exit(2)
+	case *ipv4:
+		dialNetwork = "tcp4"
+	case *ipv6:
+		dialNetwork = "tcp6"
+	}
+	if !samePerms {
+		umask = currentUmask()
+	}
+	if *tlsMin != "" {
+		v, ok := tlsVersions[*tlsMin]
+		if !ok {
+			fmt.Println("Unsupported -tls-min-version", *tlsMin)
+			os.Exit(2)
+		}
+		tlsMinVersion = v
+	}
+	if *proxy != "" {
+		u, err := url.Parse(*proxy)
+		if err != nil || u.Host == "" {
+			fmt.Println("Invalid -proxy", *proxy)
+			os.Exit(2)
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5":
+		default:
+			fmt.Println("Unsupported -proxy scheme", u.Scheme)
+			os.Exit(2)
+		}
+		proxyURL = u
+	}
+	client = newClient()
+
+	if normPerms && verbose {
+		// Directories are always created 0755, less the umask.
+		fmt.Println("Normalizing permissions: directories 0755, executable files 0755, other files 0644, no setuid, setgid or sticky bits")
+	}
+
+	uid, gid := -1, -1
+	if *chown != "" {
+		var err error
+		if uid, gid, err = parseOwner(*chown); err != nil {
+			fmt.Println("Chown:", err)
+			os.Exit(2)
+		}
+	}
+
+	var dirMode os.FileMode
+	if *destMode != "" {
+		m, err := strconv.ParseUint(*destMode, 8, 32)
+		if err != nil || m&^uint64(os.ModePerm) != 0 {
+			fmt.Println("Destination mode: not an octal permission mode:", *destMode)
+			os.Exit(2)
+		}
+		dirMode = os.FileMode(m)
+	}
+
+	if *stripVersion {
+		*renames = append(renameList{{moduleVersion, "${1}${2}"}}, *renames...)
+	}
+
+	if *prependDir != "" {
+		var err error
+		if prepend, err = parsePrepend(*prependDir); err != nil {
+			fmt.Println("Prepend:", err)
+			os.Exit(2)
+		}
+		if *flatten {
+			fmt.Println("-flatten-single-dir would undo -prepend")
+			os.Exit(2)
+		}
+	}
+
+	var nameTmpl *template.Template
+	if *nameTemplate != "" {
+		var err error
+		if nameTmpl, err = parseNameTemplate(*nameTemplate); err != nil {
+			fmt.Println("Name template:", err)
+			os.Exit(2)
+		}
+	}
+
+	if *docsOnly {
+		*includes = append(*includes, docPatterns...)
+		foldCase = true
+	}
+
+	if hardlinkFallback != "" && hardlinkFallback != "copy" {
+		fmt.Println("Unsupported -hardlink-fallback", hardlinkFallback)
+		os.Exit(2)
+	}
+
+	if onlyFiles && onlyDirs {
+		fmt.Println("Only one of -only-files and -only-dirs can be given")
+		os.Exit(2)
+	}
+
+	if bufferSize < 1 {
+		fmt.Println("The -buffer-size must be positive")
+		os.Exit(2)
+	}
+
+	if forceFormat != "" && !formats[forceFormat] {
+		fmt.Println("Unsupported -format", forceFormat)
+		os.Exit(2)
+	}
+
+	if *charset != "" {
+		if err := setCharset(*charset); err != nil {
+			fmt.Println("Charset:", err)
+			os.Exit(2)
+		}
+	}
+
+	if *clampMtime >= 0 {
+		clampTime = time.Unix(*clampMtime, 0)
+	} else if epoch := os.Getenv("SOURCE_DATE_EPOCH"); epoch != "" {
+		// https://reproducible-builds.org/specs/source-date-epoch/
+		secs, err := strconv.ParseInt(epoch, 10, 64)
+		if err != nil {
+			fmt.Println("Invalid SOURCE_DATE_EPOCH:", err)
+			os.Exit(2)
+		}
+		clampTime = time.Unix(secs, 0)
+	}
+
+	// A checksum may also be given in the URL fragment, as in
+	// https://host/file.tar.gz#sha256=..., keeping pinned downloads to a
+	// single string. The fragment is not part of what's fetched.
+	// Local paths are taken as they are; "#" is just a character there.
+	src, fragment := args[0], ""
+	if !localSource {
+		src, fragment, _ = strings.Cut(src, "#")
+	}
+	fragmentSum, err := fragmentChecksum(fragment)
+	if err != nil {
+		fmt.Println("Checksum in URL:", err)
+		os.Exit(2)
+	}
+
+	switch {
+	case fromEnv["sha256"] && fragmentSum != nil:
+		// $DL_SHA256 is a default, which the URL's own overrides.
+		expected = fragmentSum
+	case *sha256sum != "" && *checksumHex != "":
+		fmt.Println("-sha256 and -checksum are mutually exclusive")
+		os.Exit(2)
+	case *sha256sum != "":
+		expected, err = parseChecksum("sha256", *sha256sum)
+	case *checksumHex != "":
+		expected, err = detectChecksum(*checksumHex)
+	default:
+		expected = fragmentSum
+	}
+	if err != nil {
+		fmt.Println("Checksum:", err)
+		os.Exit(2)
+	}
+	if remoteMember != "" && (src == "-" || cacheDir != "" || expected != nil) {
+		fmt.Println("-remote-member can't be combined with stdin, -cache or checksum verification")
+		os.Exit(2)
+	}
+	if *continuePartial && (cacheDir == "" || src == "-") {
+		// Resuming relies on getting the very same archive again.
+		fmt.Println("-continue-partial-extract requires -cache")
+		os.Exit(2)
+	}
+	switch {
+	case *data != "" && *dataFile != "":
+		fmt.Println("-data and -data-file are mutually exclusive")
+		os.Exit(2)
+	case *data != "":
+		requestBody = []byte(*data)
+	case *dataFile != "":
+		if requestBody, err = os.ReadFile(*dataFile); err != nil {
+			fmt.Println("Data file:", err)
+			os.Exit(2)
+		}
+	}
+	if *zstdDictFile != "" {
+		if err := loadZstdDict(*zstdDictFile); err != nil {
+			fmt.Println("Zstd dictionary:", err)
+			os.Exit(2)
+		}
+	}
+	switch {
+	case requestMethod != "":
+		requestMethod = strings.ToUpper(requestMethod)
+	case requestBody != nil:
+		requestMethod = http.MethodPost
+	default:
+		requestMethod = http.MethodGet
+	}
+	if requestMethod != http.MethodGet && (*onlyNewer || remoteMember != "" || cacheDir != "") {
+		// These rely on the archive being the same for every request
+		// of the URL, and on HEAD or range requests of it.
+		fmt.Println("-method other than GET can't be combined with -only-newer, -remote-member or -cache")
+		os.Exit(2)
+	}
+
+	if *output != "" && !*downloadOnly {
+		fmt.Println("-o only applies to fetch and -download-only")
+		os.Exit(2)
+	}
+	if *postCmd != "" && (*downloadOnly || *listOnly || *toTar != "" || dryRun) {
+		fmt.Println("-post-cmd only applies to extracting")
+		os.Exit(2)
+	}
+	if *output == "-" && printURL {
+		fmt.Println("-print-url-after-redirects can't be combined with -o to stdout")
+		os.Exit(2)
+	}
+	if windowed() && (cacheDir != "" || remoteMember != "") {
+		fmt.Println("-skip and -limit can't be combined with -cache or -remote-member")
+		os.Exit(2)
+	}
+
+	if expected != nil && weakAlgos[expected.algo] {
+		warn("%s is a weak hash algorithm that doesn't protect against tampering; prefer sha256 or better", expected.algo)
+		if !*allowInsecureHashes {
+			fmt.Println("Refusing to verify against", expected.algo, "without -allow-insecure-hashes")
+			os.Exit(2)
+		}
+	}
+
+	// Without verifying the contents of what we download, plain HTTP
+	// leaves us open to having anything at all injected.
+	if u, err := url.Parse(src); err == nil && u.Scheme == "http" && expected == nil && !localSource {
+		if *requireHTTPS {
+			fmt.Println("Refusing to download over insecure http:// (-require-https)")
+			os.Exit(1)
+		}
+		warn("downloading over insecure http:// without verification")
+	}
+
+	ctx := context.Background()
+	if *timeLimit > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeLimit)
+		defer cancel()
+	}
+
+	if *listOnly {
+		newLister, ok := listFormats[*listFormat]
+		if !ok {
+			fmt.Println("Unsupported -list-format", *listFormat)
+			os.Exit(2)
+		}
+		// Keep the listing clean, for -list-format json and csv to
+		// be parsed; everything else we print goes to stderr instead.
+		lister := newLister(os.Stdout)
+		os.Stdout = os.Stderr
+		err := list(ctx, src, *strip, lister.entry)
+		if ferr := lister.flush(); err == nil {
+			err = ferr
+		}
+		if err != nil {
+			fmt.Println("List:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *toTar != "" {
+		if *toTar == "-" && printURL {
+			fmt.Println("-print-url-after-redirects can't be combined with -to-tar to stdout")
+			os.Exit(2)
+		}
+		if err := transform(ctx, src, *toTar, *strip); err != nil {
+			fmt.Println("Transform:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *downloadOnly {
+		out := *output
+		if out == "" {
+			out = *destination
+		}
+		if out == "" && src == "-" {
+			fmt.Println("A -destination is required when reading from stdin")
+			os.Exit(2)
+		}
+		if out == "" && nameTmpl != nil {
+			if out, err = defaultName(nameTmpl, urlFileName(src)); err != nil {
+				fmt.Println("Name template:", err)
+				os.Exit(1)
+			}
+		}
+		if out == "" {
+			out = urlFileName(src)
+		}
+		if err := saveArchive(ctx, src, out); err != nil {
+			fmt.Println("Download:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	dst := *destination
+	if dst == "" && src == "-" {
+		fmt.Println("A -destination is required when reading from stdin")
+		os.Exit(2)
+	}
+	if dst == "" {
+		if dst, err = defaultName(nameTmpl, urlFileName(src)); err != nil {
+			fmt.Println("Name template:", err)
+			os.Exit(1)
+		}
+	}
+
+	if dryRun {
+		// Report every entry that would fail, not just the first.
+		keepGoing = true
+		err := download(ctx, src, dst, *strip)
+		overwritten.report()
+		skipped.report()
+		if err != nil {
+			fmt.Println("Dry run:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Versions go side by side in the destination, as dst/1.2.3 and so
+	// on, so that switching between them is a matter of a symlink.
+	versionsDir := ""
+	if *versionedDir {
+		if *version == "" {
+			*version = versionFromURL(src)
+		}
+		if *version == "" {
+			fmt.Println("No version in the URL; give one with -version")
+			os.Exit(2)
+		}
+		if err := checkVersion(*version); err != nil {
+			fmt.Println("Version:", err)
+			os.Exit(2)
+		}
+		versionsDir = dst
+		dst = filepath.Join(dst, *version)
+	}
+
+	tmp := dst + *tempSuffix
+	if *tempSuffix == "" {
+		fmt.Println("The -temp-suffix can't be empty")
+		os.Exit(2)
+	}
+
+	// The temporary, lock and marker files all live next to the
+	// destination, so the parent must exist before any of them.
+	if *makeParents {
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			fmt.Println("Create parent directory:", err)
+			os.Exit(1)
+		}
+	}
+
+	// Hold a lock on a sibling file for the duration of the operation so
+	// that concurrent invocations don't trample each other's temporary.
+	// The OS drops the lock if we die, but be explicit about it on the
+	// common termination signals.
+	lock, err := lockFile(dst+".lock", !*noWait)
+	if err != nil {
+		fmt.Println("Lock:", err)
+		os.Exit(1)
+	}
+	defer lock.Close()
+
+	// fail exits after a failure, removing the temporary unless asked to
+	// keep it, or to resume into it with -skip-existing or
+	// -continue-partial-extract.
+	resumable := skipExisting || *continuePartial
+	fail := func() {
+		if *keepTemp || resumable {
+			if verbose {
+				fmt.Println("Keeping temporary", tmp)
+			}
+		} else {
+			os.RemoveAll(tmp)
+		}
+		failOnWarnings()
+		os.Exit(1)
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		lock.Close()
+		fail()
+	}()
+
+	if *onExist != "" && !existChoices[*onExist] {
+		fmt.Println("Unsupported -on-exist", *onExist)
+		os.Exit(2)
+	}
+	if _, err := os.Lstat(dst); err == nil && *onExist == existPrompt {
+		if src == "-" {
+			fmt.Println("Can't prompt with the archive on stdin")
+			os.Exit(1)
+		}
+		if *onExist, err = askOnExist(dst); err != nil {
+			fmt.Println("On exist:", err)
+			os.Exit(1)
+		}
+	}
+	if _, err := os.Lstat(dst); err == nil && *onExist == existAbort {
+		fmt.Println("Destination", dst, "exists; aborting")
+		os.Exit(1)
+	}
+
+	// Anything left over by an earlier run would otherwise end up in
+	// this one's destination, unless it's there to be resumed.
+	journalPath := tmp + ".journal"
+	if !resumable {
+		if err := os.RemoveAll(tmp); err != nil {
+			fmt.Println("Remove old temporary:", err)
+			os.Exit(1)
+		}
+	}
+	if *continuePartial {
+		if extractJournal, err = openJournal(journalPath, src); err != nil {
+			fmt.Println("Journal:", err)
+			os.Exit(1)
+		}
+		// Without a journal of this source to resume, what's in the
+		// temporary is left over from some other run.
+		if extractJournal.done == 0 && !skipExisting {
+			if err := os.RemoveAll(tmp); err != nil {
+				fmt.Println("Remove old temporary:", err)
+				os.Exit(1)
+			}
+		}
+	}
+
+	// Whatever signalled success for a previous run doesn't apply to
+	// this one.
+	if *doneFile != "" {
+		if err := os.Remove(*doneFile); err != nil && !os.IsNotExist(err) {
+			fmt.Println("Remove done file:", err)
+			os.Exit(1)
+		}
+	}
+
+	// The marker records the ETag and Last-Modified of what was last
+	// downloaded into the destination.
+	markerPath := dst + ".marker"
+	var marker string
+	if *onlyNewer {
+		marker, err = remoteMarker(ctx, src)
+		if err != nil {
+			// Not every server does HEAD; without it there's no
+			// telling, so download as usual.
+			if verbose {
+				fmt.Printf("Check for changes: %v; downloading anyway\n", err)
+			}
+			marker = ""
+		}
+		if _, err := os.Stat(dst); err == nil && marker != "" {
+			if old, err := os.ReadFile(markerPath); err == nil && string(old) == marker {
+				if verbose {
+					fmt.Println("Destination is up to date")
+				}
+				failOnWarnings()
+				if err := writeDoneFile(*doneFile); err != nil {
+					fmt.Println("Write done file:", err)
+					os.Exit(1)
+				}
+				return
+			}
+		}
+		// The destination is out of date, or may be. It's there to be
+		// kept up to date, so replace it unless told otherwise.
+		if *onExist == "" {
+			*onExist = existOverwrite
+		}
+	}
+
+	step := "Download"
+	if localSource {
+		step = "Extract"
+	}
+	if verbose {
+		fmt.Println("Destination is", dst)
+		fmt.Println("Temporary is", tmp)
+		fmt.Printf("%sing...\n", step)
+	}
+
+	if *progress {
+		meter = newProgressMeter()
+		meter.run()
+	}
+	err = downloadRetrying(ctx, src, tmp, *strip)
+	if meter != nil {
+		meter.close()
+	}
+	overwritten.report()
+	skipped.report()
+	var failed entryErrors // with -partial-ok
+	if err != nil {
+		fmt.Printf("%s: %v\n", step, err)
+		if ctx.Err() != nil || !*partialOK || !errors.As(err, &failed) {
+			fail()
+		}
+	}
+	partial := len(failed) > 0
+
+	if verifyExtracted {
+		if err := extracted.verify(); err != nil {
+			fmt.Println("Verify:", err)
+			fail()
+		}
+	}
+
+	// With -strict, warnings fail the run before anything of it shows:
+	// the destination, the done file or the -post-cmd.
+	if strictFailed() {
+		fail()
+	}
+
+	if verbose {
+		fmt.Println("Moving destination into place...")
+	}
+
+	// Nothing may have been extracted, as with -only-files on an
+	// archive of directories; that still makes for a destination.
+	if err := os.MkdirAll(tmp, 0755); err != nil {
+		fmt.Println("Create destination:", err)
+		fail()
+	}
+
+	if *flatten {
+		if err := flattenSingleDir(tmp); err != nil {
+			fmt.Println("Flatten:", err)
+			fail()
+		}
+	}
+
+	if *destMode != "" {
+		if err := os.Chmod(tmp, dirMode); err != nil {
+			fmt.Println("Destination mode:", err)
+			fail()
+		}
+	}
+
+	if uid != -1 || gid != -1 {
+		if err := chownTree(tmp, uid, gid); err != nil {
+			fmt.Println("Chown:", err)
+			fail()
+		}
+	}
+
+	_, err = os.Lstat(dst)
+	exists := err == nil
+	switch {
+	case exists && *onExist == existMerge:
+		if err := mergeInto(tmp, dst); err != nil {
+			fmt.Println("Merge into destination:", err)
+			fail()
+		}
+		if *destMode != "" {
+			if err := os.Chmod(dst, dirMode); err != nil {
+				fmt.Println("Destination mode:", err)
+				fail()
+			}
+		}
+	case exists && *onExist == existOverwrite:
+		if err := os.RemoveAll(dst); err != nil {
+			fmt.Println("Remove destination:", err)
+			fail()
+		}
+		fallthrough
+	default:
+		if err := os.Rename(tmp, dst); err != nil {
+			fmt.Println("Rename temporary:", err)
+			fail()
+		}
+	}
+
+	if extractJournal != nil {
+		os.Remove(journalPath)
+	}
+
+	// Nothing is to be pointed at a version that's incomplete.
+	if *currentLink && versionsDir != "" && !partial {
+		if err := updateCurrentLink(versionsDir, *version); err != nil {
+			fmt.Println("Update current link:", err)
+			os.Exit(1)
+		}
+	}
+
+	// An incomplete destination must not pass for up to date next time.
+	if marker != "" && partial {
+		os.Remove(markerPath)
+	} else if marker != "" {
+		if err := os.WriteFile(markerPath, []byte(marker), 0644); err != nil {
+			fmt.Println("Write marker:", err)
+			os.Exit(1)
+		}
+	}
+
+	if *postCmd != "" && !partial {
+		if verbose {
+			fmt.Println("Running", *postCmd)
+		}
+		if code, err := runPostCmd(*postCmd, dst); err != nil {
+			fmt.Println("Post command:", err)
+			os.Exit(code)
+		}
+	}
+
+	if partial {
+		fmt.Printf("Destination %s is incomplete: %d entries failed\n", dst, len(failed))
+		os.Exit(exitPartial)
+	}
+	if err := writeDoneFile(*doneFile); err != nil {
+		fmt.Println("Write done file:", err)
+		os.Exit(1)
+	}
+}
+
+func download(ctx context.Context, url, destination string, strip int) error {
+	destination = longPath(destination)
+
+	if remoteMember != "" {
+		return extractRemoteMember(ctx, url, destination, strip)
+	}
+
+	body, status, ctype, err := openArchive(ctx, url)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	// The size is enforced on what's actually read, since there may be
+	// no Content-Length to check up front.
+	var r io.Reader = body
+	var limit *sizeLimitReader
+	if maxSize > 0 {
+		limit = &sizeLimitReader{r: r, remain: maxSize}
+		r = limit
+	}
+
+	// Hash exactly the bytes we got, before any decompression. Each
+	// attempt starts over with a fresh hash of a fresh body, and with a
+	// redirect that's the body of the final response.
+	var h *asyncHash
+	if expected != nil {
+		h = newAsyncHash(expected.newHash())
+		defer h.finish()
+		r = io.TeeReader(r, h)
+	}
+
+	br := bufio.NewReader(r)
+	if err := checkNotHTML(br, status); err != nil {
+		return err
+	}
+
+	err = extract(ctx, url, ctype, br, destination, strip)
+	if limit != nil && limit.err != nil {
+		// Whatever the extraction made of the cut off archive.
+		return limit.err
+	}
+	var ee entryErrors
+	if (h == nil && limit == nil) || (err != nil && !errors.As(err, &ee)) {
+		return err
+	}
+
+	// The archive formats may well end before the data does; make sure
+	// everything is hashed and counted.
+	if _, cerr := io.Copy(io.Discard, br); cerr != nil {
+		if limit != nil && limit.err != nil {
+			// Too large, not corrupt; that wouldn't change.
+			return limit.err
+		}
+		return &corruptError{cerr}
+	}
+	if h != nil {
+		if verr := expected.verify(h.finish()); verr != nil {
+			return verr
+		}
+	}
+	return err
+}
+
+// sizeLimitReader fails once reading more than remain bytes. The read that
+// goes past the limit returns no data, so that what has been read of the
+// archive can't appear complete.
+type sizeLimitReader struct {
+	r      io.Reader
+	remain int64
+	err    error
+}
+
+func (l *sizeLimitReader) Read(p []byte) (int, error) {
+	if l.err != nil {
+		return 0, l.err
+	}
+	if int64(len(p)) > l.remain+1 {
+		p = p[:l.remain+1]
+	}
+	n, err := l.r.Read(p)
+	if int64(n) > l.remain {
+		l.err = fmt.Errorf("archive is larger than the -max-size of %d bytes", maxSize)
+		return 0, l.err
+	}
+	l.remain -= int64(n)
+	return n, err
+}
+
+// extract extracts the archive read from br, as served with the Content-Type
+// ctype if any, into destination.
+func extract(ctx context.Context, url, ctype string, br *bufio.Reader, destination string, strip int) error {
+	format := forceFormat
+	if format == "" {
+		format = detectFormat(url, ctype, br)
+	}
+	// The archive if it was read whole already.
+	var data []byte
+	if format == "exe" {
+		var err error
+		if data, err = ioutil.ReadAll(br); err != nil {
+			return &corruptError{err}
+		}
+		if zipStart(data) >= 0 {
+			format = "zip"
+		} else {
+			// Perhaps something else that happens to start with
+			// "MZ"; it's not a tar, or it wouldn't be "exe".
+			br = bufio.NewReader(bytes.NewReader(data))
+			format = sniffFormat(url, ctype, br)
+			data = nil
+			if format == "tar" {
+				return errors.New("executable without an appended zip archive")
+			}
+		}
+	}
+	archiveFormat = format
+
+	if format == "zip" {
+		bs := data
+		if bs == nil {
+			var err error
+			if bs, err = ioutil.ReadAll(br); err != nil {
+				return &corruptError{err}
+			}
+		}
+		start := zipStart(bs)
+		if start < 0 && bytes.HasPrefix(bs, magicPE) {
+			return errors.New("executable without an appended zip archive")
+		} else if start > 0 {
+			if verbose {
+				fmt.Printf("Zip archive starts at offset %d\n", start)
+			}
+			bs = bs[start:]
+		}
+		return unzip(ctx, bs, destination, strip)
+	}
+
+	r, err := decompressingReader(br, format)
+	if errors.Is(err, errUnsupportedCompression) || errors.Is(err, errZstdDict) {
+		return err
+	} else if err != nil {
+		return &corruptError{fmt.Errorf("not a valid %s archive: %v", format, err)}
+	}
+
+	// Compressed cpio archives are only recognizable once decompressed.
+	dr := bufio.NewReader(r)
+	if strings.HasPrefix(format, "cpio") || forceFormat == "" && isCpio(dr) {
+		if !strings.HasPrefix(format, "cpio") {
+			archiveFormat = strings.Replace(format, "tar", "cpio", 1)
+		}
+		return unarchive(ctx, newCpioReader(dr), "cpio", destination, strip)
+	}
+	if gz, ok := r.(*gzip.Reader); ok && forceFormat == "" && !isTar(dr) {
+		return extractSingle(ctx, url, gz, dr, destination)
+	}
+	return untar(ctx, dr, destination, strip)
+}
+
+// openArchive returns the archive body for url, which is either read from
+// stdin ("-"), a local file, the cache or downloaded, and the HTTP status and
+// Content-Type it came with. Only downloads have a Content-Type.
+func openArchive(ctx context.Context, url string) (io.ReadCloser, int, string, error) {
+	switch {
+	case url == "-":
+		return window(ioutil.NopCloser(os.Stdin)), http.StatusOK, "", nil
+	case localSource:
+		fd, err := os.Open(url)
+		if err != nil {
+			return nil, 0, "", err
+		}
+		return window(fd), http.StatusOK, "", nil
+	case cacheDir != "":
+		body, err := openCached(ctx, url)
+		return body, http.StatusOK, "", err
+	default:
+		resp, err := fetch(ctx, url)
+		if err != nil {
+			return nil, 0, "", err
+		}
+		ctype := resp.Header.Get("Content-Type")
+		if resp.StatusCode == http.StatusPartialContent && windowed() {
+			// The server applied the -skip/-limit window.
+			return resp.Body, resp.StatusCode, ctype, nil
+		}
+		return window(resp.Body), resp.StatusCode, ctype, nil
+	}
+}
+
+// fetchOnce performs the GET request for url, returning the response if
+// it was successful.
+func fetchOnce(ctx context.Context, url string) (*http.Response, error) {
+	var body io.Reader
+	if requestBody != nil {
+		body = bytes.NewReader(requestBody)
+	}
+	req, err := http.NewRequestWithContext(ctx, requestMethod, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if requestBody != nil {
+		req.Header.Set("Content-Type", bodyContentType(requestBody))
+	}
+	authorize(req)
+	setRange(req)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := responseError(resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	if err := checkUnrequestedRange(resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	if verbose {
+		printResponse(resp)
+	}
+	if printURL {
+		printFinalURL.Do(func() { fmt.Println(resp.Request.URL) })
+	}
+	if maxSize > 0 && resp.ContentLength > maxSize {
+		resp.Body.Close()
+		return nil, fmt.Errorf("archive size %d is larger than the -max-size of %d bytes", resp.ContentLength, maxSize)
+	}
+	resp.Body = meter.track(resp.Body, resp.ContentLength)
+	return resp, nil
+}
+
+// requestMethod and requestBody are the -method and -data to fetch the
+// archive with, for endpoints that need more than a plain GET.
+var (
+	requestMethod        = http.MethodGet
+	requestBody   []byte = nil
+)
+
+// bodyContentType returns the Content-Type of a -data body: JSON if it's
+// valid as such, otherwise form data as curl assumes.
+func bodyContentType(body []byte) string {
+	if json.Valid(body) {
+		return "application/json"
+	}
+	return "application/x-www-form-urlencoded"
+}
+
+// printURL, for -print-url-after-redirects, prints the URL the archive is
+// fetched from, after any redirects, once per invocation.
+var (
+	printURL      = false
+	printFinalURL sync.Once
+)
+
+// printResponse prints the final URL and the headers of resp that matter
+// when figuring out why a download didn't turn out as expected.
+func printResponse(resp *http.Response) {
+	fmt.Println("Fetched", resp.Request.URL)
+	for _, h := range []string{"Content-Type", "Content-Length", "Content-Encoding", "ETag", "Last-Modified"} {
+		if v := resp.Header.Get(h); v != "" {
+			fmt.Printf("   %s: %s\n", h, v)
+		}
+	}
+}
+
+// checkNotHTML returns an error if br is about to return an HTML page.
+// Auth walls and broken mirrors like to answer with a 200 and a web page,
+// which otherwise fails in confusing ways further down.
+func checkNotHTML(br *bufio.Reader, status int) error {
+	if head, _ := br.Peek(512); looksLikeHTML(head) {
+		return fmt.Errorf("expected an archive but got an HTML page (status %d)", status)
+	}
+	return nil
+}
+
+// looksLikeHTML returns true if the data starts like an HTML document.
+func looksLikeHTML(data []byte) bool {
+	data = bytes.ToLower(bytes.TrimLeft(data, "\xef\xbb\xbf \t\r\n"))
+	return bytes.HasPrefix(data, []byte("<!doctype html")) || bytes.HasPrefix(data, []byte("<html"))
+}
+
+// saveArchive downloads the archive at url to the file output ("-" meaning
+// stdout), as is.
+func saveArchive(ctx context.Context, url, output string) error {
+	var stdout *os.File
+	if output == "-" {
+		// Keep the archive clean; everything else we print goes to
+		// stderr instead.
+		stdout = os.Stdout
+		os.Stdout = os.Stderr
+	}
+
+	body, status, _, err := openArchive(ctx, url)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	var r io.Reader = body
+	if maxSize > 0 {
+		r = &sizeLimitReader{r: r, remain: maxSize}
+	}
+	var h hash.Hash
+	if expected != nil {
+		h = expected.newHash()
+		r = io.TeeReader(r, h)
+	}
+	br := bufio.NewReader(r)
+	if err := checkNotHTML(br, status); err != nil {
+		return err
+	}
+
+	// Verify before the file is moved into place, not after. On stdout
+	// there's no such place, so a mismatch is only known at the end.
+	var in io.Reader = br
+	if h != nil {
+		in = &verifyingReader{r: br, h: h}
+	}
+	if stdout != nil {
+		_, err := io.Copy(stdout, in)
+		return err
+	}
+	if verbose {
+		fmt.Println("Saving to", output)
+	}
+	return writeNewFileAtomic(output, in, fileMode(0644))
+}
+
+// transform downloads the archive at url and writes its entries, after
+// stripping, as a tar file to output ("-" meaning stdout).
+func transform(ctx context.Context, url, output string, strip int) error {
+	var out io.WriteCloser
+	if output == "-" {
+		// Keep the tar stream clean; everything else we print goes to
+		// stderr instead.
+		out = os.Stdout
+		os.Stdout = os.Stderr
+	} else {
+		fd, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		out = fd
+	}
+
+	tarOut = tar.NewWriter(out)
+	err := download(ctx, url, "", strip)
+	if err == nil {
+		err = tarOut.Close()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil && output != "-" {
+		os.Remove(output)
+	}
+	return err
+}
+
+// writeDoneFile creates the empty file at path, if set. It's written under
+// a temporary name first so that it appears atomically.
+func writeDoneFile(path string) error {
+	if path == "" {
+		return nil
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, nil, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// remoteMarker returns a string identifying the current version of the
+// resource at url, based on the ETag and Last-Modified headers of a HEAD
+// request. It's empty if the server provides neither.
+func remoteMarker(ctx context.Context, url string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return "", err
+	}
+	authorize(req)
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if err := responseError(resp); err != nil {
+		return "", err
+	}
+
+	etag := resp.Header.Get("ETag")
+	lastMod := resp.Header.Get("Last-Modified")
+	if etag == "" && lastMod == "" {
+		return "", nil
+	}
+	return fmt.Sprintf("ETag: %s\nLast-Modified: %s\n", etag, lastMod), nil
+}
+
+// overwritten tracks the non-directory entries extracted so far, to report
+// those that replaced an earlier entry with the same name.
+var overwritten = &nameTracker{seen: make(map[string]bool)}
+
+type nameTracker struct {
+	mut   sync.Mutex
+	seen  map[string]bool
+	dupes []string
+}
+
+// see records name and returns true if it had already been seen.
+func (t *nameTracker) see(name string) bool {
+	name = path.Clean(name)
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	dupe := t.seen[name]
+	if dupe {
+		t.dupes = append(t.dupes, name)
+	}
+	t.seen[name] = true
+	return dupe
+}
+
+// reset forgets all names seen.
+func (t *nameTracker) reset() {
+	t.mut.Lock()
+	t.seen = make(map[string]bool)
+	t.dupes = nil
+	t.mut.Unlock()
+}
+
+// report warns about any duplicates seen, listing them in verbose mode.
+func (t *nameTracker) report() {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	if len(t.dupes) == 0 {
+		return
+	}
+	warn("%d entries overwrote an earlier entry with the same name", len(t.dupes))
+	if verbose {
+		for _, name := range t.dupes {
+			fmt.Println(" - duplicate:", name)
+		}
+	}
+}
 
-	"bytes"
-	"compress/gzip"
-	"io/ioutil"
-	"path"
-	"strings"
+// strict, for -strict, makes any warning fail the run once it's done.
+var (
+	strict   = false
+	warnings []string
+	warnMut  sync.Mutex
 )
 
-var verbose = false
+// warn prints a warning message to stderr, recording it for -strict.
+func warn(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	fmt.Fprintln(os.Stderr, "Warning:", msg)
+	warnMut.Lock()
+	warnings = append(warnings, msg)
+	warnMut.Unlock()
+}
 
-func main() {
-	destination := flag.String("destination", "", "Destination to unpack into")
-	strip := flag.Int("strip", 0, "Strip path components from archive")
-	flag.BoolVar(&verbose, "v", verbose, "Verbose output")
-	flag.Parse()
+// strictFailed returns true if -strict is to fail the run for warnings.
+func strictFailed() bool {
+	warnMut.Lock()
+	defer warnMut.Unlock()
+	return strict && len(warnings) > 0
+}
 
-	if flag.NArg() != 1 {
-		fmt.Println("URL as only parameter")
-		os.Exit(2)
+// failOnWarnings lists the warnings and exits unsuccessfully if there were
+// any with -strict.
+func failOnWarnings() {
+	warnMut.Lock()
+	defer warnMut.Unlock()
+	if !strict || len(warnings) == 0 {
+		return
+	}
+	fmt.Printf("Failing on %d warnings (-strict):\n", len(warnings))
+	for _, msg := range warnings {
+		fmt.Println(" -", msg)
 	}
+	os.Exit(1)
+}
 
-	dst := *destination
-	if dst == "" {
-		base := filepath.Base(flag.Arg(0))
-		for ext := filepath.Ext(base); ext != ""; ext = filepath.Ext(base) {
-			base = base[:len(base)-len(ext)]
-		}
+// indices is the set of entry indices selected with -index; all entries
+// are selected when it's empty.
+var indices = indexSet{}
 
-		dst = base
-	}
-	tmp := dst + ".tmp"
+type indexSet map[int]bool
 
-	if verbose {
-		fmt.Println("Destination is", dst)
-		fmt.Println("Downloading...")
-	}
+func (s indexSet) String() string {
+	return fmt.Sprint(map[int]bool(s))
+}
 
-	if err := download(flag.Arg(0), tmp, *strip); err != nil {
-		fmt.Println("Download:", err)
-		os.Exit(1)
+func (s indexSet) Set(v string) error {
+	i, err := strconv.Atoi(v)
+	if err != nil || i < 0 {
+		return fmt.Errorf("invalid index %q", v)
 	}
+	s[i] = true
+	return nil
+}
 
-	if err := os.Rename(tmp, dst); err != nil {
-		if verbose {
-			fmt.Println("Move destination into place...")
-		}
+func (s indexSet) selected(i int) bool {
+	return len(s) == 0 || s[i]
+}
 
-		fmt.Println("Rename temporary:", err)
-		os.Exit(1)
+// entryErrors collects the failures of individual archive entries when
+// extracting with -keep-going.
+type entryErrors []error
+
+func (e entryErrors) Error() string {
+	lines := make([]string, 0, len(e)+1)
+	lines = append(lines, fmt.Sprintf("%d entries failed:", len(e)))
+	for _, err := range e {
+		lines = append(lines, " - "+err.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (e entryErrors) orNil() error {
+	if len(e) == 0 {
+		return nil
 	}
+	return e
 }
 
-func download(url, destination string, strip int) error {
-	resp, err := http.Get(url)
+// --- https://github.com/mholt/archiver/ ---
+
+func unzip(ctx context.Context, data []byte, destination string, strip int) error {
+	r, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
 	if err != nil {
-		return err
+		return &corruptError{err}
+	}
+	if maxFiles > 0 && len(r.File) > maxFiles {
+		return fmt.Errorf("archive has %d entries, more than the limit of %d", len(r.File), maxFiles)
+	}
+	if metadata && r.Comment != "" {
+		fmt.Println("Archive comment:", r.Comment)
+	}
+	if appInfo {
+		printAppInfo(r.File)
 	}
-	if resp.StatusCode != http.StatusOK {
-		return errors.New(resp.Status)
+	if workers > 1 && tarOut == nil && listEntry == nil && !dryRun {
+		return unzipParallel(ctx, r.File, destination, strip)
 	}
 
-	if path.Ext(url) == ".zip" {
-		bs, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
+	var errs entryErrors
+	for i, zf := range r.File {
+		if err := ctx.Err(); err != nil {
 			return err
 		}
-		return unzip(bs, destination, strip)
+		if !indices.selected(i) {
+			skipped.add(zf.Name, "index")
+			continue
+		}
+		if err := zipEntryError(unzipFile(i, zf, destination, strip), i, zf); err != nil {
+			if !keepGoing {
+				return err
+			}
+			errs = append(errs, err)
+		}
 	}
 
-	return untar(resp.Body, destination, strip)
+	return errs.orNil()
 }
 
-// --- https://github.com/mholt/archiver/ ---
+// unzipParallel extracts files using a pool of workers, which pays off
+// for archives with many entries as zip allows random access to each.
+func unzipParallel(ctx context.Context, files []*zip.File, destination string, strip int) error {
+	wctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-func unzip(data []byte, destination string, strip int) error {
-	r, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
-	if err != nil {
-		return err
+	var mut sync.Mutex
+	var errs entryErrors
+	var wg sync.WaitGroup
+	work := make(chan int)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				if err := zipEntryError(unzipFile(i, files[i], destination, strip), i, files[i]); err != nil {
+					mut.Lock()
+					errs = append(errs, err)
+					mut.Unlock()
+					if !keepGoing {
+						cancel()
+					}
+				}
+			}
+		}()
 	}
-	for _, zf := range r.File {
-		if err := unzipFile(zf, destination, strip); err != nil {
-			return err
+
+loop:
+	for i := range files {
+		if !indices.selected(i) {
+			skipped.add(files[i].Name, "index")
+			continue
+		}
+		select {
+		case work <- i:
+		case <-wctx.Done():
+			break loop
 		}
 	}
+	close(work)
+	wg.Wait()
 
-	return nil
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if !keepGoing && len(errs) > 0 {
+		return errs[0]
+	}
+	return errs.orNil()
 }
 
-func unzipFile(zf *zip.File, destination string, strip int) error {
+func unzipFile(index int, zf *zip.File, destination string, strip int) error {
+	if err := checkAbsolute(zf.Name); err != nil {
+		return err
+	}
 	name := zf.Name
-	if strip > 0 {
-		parts := strings.Split(filepath.ToSlash(name), "/")
-		if len(parts) <= strip {
-			return nil
-		}
-		name = strings.Join(parts[strip:], "/")
+	if charsetTable != nil && zf.NonUTF8 {
+		name = decodeName(name)
+	}
+	name, reason := entryName(name, strip)
+	if reason != "" {
+		skipped.add(zf.Name, reason)
+		return nil
+	} else if name == "" {
+		return nil
+	}
+
+	name, err := checkName(name)
+	if err != nil {
+		return err
+	}
+	if err := checkEscape(name); err != nil {
+		return err
+	}
+	if reason, err := filtered(destination, name, strings.HasSuffix(name, "/") || zf.FileInfo().IsDir()); reason != "" {
+		skipped.add(name, reason)
+		return err
 	}
 
-	if name == "" {
+	if listEntry != nil {
+		listEntry(entryInfo{
+			Index:   index,
+			Name:    name,
+			Size:    int64(zf.UncompressedSize64),
+			Mode:    zf.FileInfo().Mode(),
+			ModTime: zf.Modified,
+			Comment: zf.Comment,
+		})
 		return nil
 	}
 
 	if verbose {
-		fmt.Println(" -", name)
+		isFile := tarOut == nil && !strings.HasSuffix(name, "/") && !zf.FileInfo().IsDir()
+		printName(name, zf.FileInfo().Mode(), isFile)
+	}
+	if metadata && zf.Comment != "" {
+		fmt.Printf("%s: comment: %s\n", name, zf.Comment)
+	}
+
+	if tarOut != nil {
+		hdr, err := tar.FileInfoHeader(zf.FileInfo(), "")
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		rc, err := openZipFile(zf)
+		if err != nil {
+			return fmt.Errorf("%s: open compressed file: %v", name, err)
+		}
+		defer rc.Close()
+		return retarFile(name, *hdr, rc)
 	}
 
-	if strings.HasSuffix(name, "/") {
+	// Stripping preserves the trailing slash of directory entries, since
+	// splitting "top/sub/" leaves an empty last component. Some tools
+	// omit the slash and only mark directories in the mode bits.
+	if strings.HasSuffix(name, "/") || zf.FileInfo().IsDir() {
+		if dryRun {
+			printDryRun(filepath.Join(destination, name), zf.FileInfo().Mode(), false)
+			return nil
+		}
 		return mkdir(filepath.Join(destination, name))
 	}
+	dupe := overwritten.see(name)
+
+	fpath := filepath.Join(destination, name)
+	if dryRun {
+		printDryRun(fpath, fileMode(zf.FileInfo().Mode()), dupe)
+		return nil
+	}
+	if skipExisting && unchanged(fpath, int64(zf.UncompressedSize64), zf.Modified) {
+		skipped.add(name, "unchanged")
+		return nil
+	}
 
-	rc, err := zf.Open()
+	rc, err := openZipFile(zf)
 	if err != nil {
 		return fmt.Errorf("%s: open compressed file: %v", name, err)
 	}
 	defer rc.Close()
 
-	return writeNewFile(filepath.Join(destination, name), rc, zf.FileInfo().Mode())
+	// A CRC mismatch surfaces from the final read, which writeNewFile
+	// would report as just another write failure.
+	cr := &readErrReader{r: rc}
+	if err := writeNewFile(fpath, cr, zf.FileInfo().Mode()); err != nil {
+		if errors.Is(cr.err, zip.ErrChecksum) {
+			return &corruptError{fmt.Errorf("%s: CRC32 mismatch, archive may be corrupt", name)}
+		} else if cr.err != nil {
+			return &corruptError{fmt.Errorf("%s: reading archive: %v", name, cr.err)}
+		}
+		return err
+	}
+	extracted.add(fpath, int64(zf.UncompressedSize64), zf.CRC32, zipHasCRC(zf))
+	return setModTime(fpath, zf.Modified)
 }
 
 // untar un-tarballs the contents of tr into destination.
-func untar(r io.Reader, destination string, strip int) error {
-	gr, err := gzip.NewReader(r)
-	if err == nil {
-		r = gr
+func untar(ctx context.Context, r io.Reader, destination string, strip int) error {
+	return unarchive(ctx, newTarOffsetReader(r), "tar", destination, strip)
+}
+
+// entryReader is an archive reader in the style of tar.Reader: Next advances
+// to the next entry, the contents of which are then read from it.
+type entryReader interface {
+	io.Reader
+	Next() (*tar.Header, error)
+}
+
+// unarchive extracts the entries read from tr, which is an archive of the
+// given kind, into destination.
+func unarchive(ctx context.Context, tr entryReader, kind string, destination string, strip int) error {
+	var errs entryErrors
+	entries := 0
+	resume := 0
+	deferredLinks, leftOut = nil, map[string]bool{}
+	if extractJournal != nil {
+		// Whichever way this ends, record how far it got.
+		defer extractJournal.sync()
+		resume = extractJournal.done
+		if verbose && resume > 0 {
+			fmt.Printf("Resuming after entry %d\n", resume-1)
+		}
 	}
-	tr := tar.NewReader(r)
 	for {
 		header, err := tr.Next()
 		if err == io.EOF {
 			break
 		} else if err != nil {
+			return &corruptError{fmt.Errorf("reading %s: %v", kind, err)}
+		}
+
+		index := entries
+		if header.Typeflag != tar.TypeXGlobalHeader {
+			entries++
+			if maxFiles > 0 && entries > maxFiles {
+				return fmt.Errorf("archive has more than the limit of %d entries", maxFiles)
+			}
+			if !indices.selected(index) {
+				skipped.add(header.Name, "index")
+				if name, reason := entryName(header.Name, strip); reason == "" && name != "" {
+					if name, err := checkName(name); err == nil {
+						leftOut[filepath.Join(destination, name)] = true
+					}
+				}
+				continue
+			}
+			if index < resume {
+				skipped.add(header.Name, "resumed")
+				continue
+			}
+		}
+
+		if err := ctx.Err(); err != nil {
 			return err
 		}
 
-		if err := untarFile(tr, header, destination, strip); err != nil {
+		offset := int64(-1)
+		if o, ok := tr.(interface{ offset() int64 }); ok {
+			offset = o.offset()
+		}
+		if err := tarEntryError(untarFile(index, tr, header, destination, strip), index, header, offset); err != nil {
+			if !keepGoing {
+				return err
+			}
+			errs = append(errs, err)
+		}
+		// Entries that failed need doing again on resume, and so does
+		// everything after them.
+		if extractJournal != nil && len(errs) == 0 && header.Typeflag != tar.TypeXGlobalHeader {
+			if err := extractJournal.advance(index + 1); err != nil {
+				return err
+			}
+		}
+	}
+	for _, err := range createDeferredLinks() {
+		if !keepGoing {
 			return err
 		}
+		errs = append(errs, err)
 	}
-	return nil
+	return errs.orNil()
 }
 
 // untarFile untars a single file from tr with header header into destination.
-func untarFile(tr *tar.Reader, header *tar.Header, destination string, strip int) error {
-	name := header.Name
-	if strip > 0 {
-		parts := strings.Split(filepath.ToSlash(name), "/")
-		if len(parts) <= strip {
+func untarFile(index int, tr io.Reader, header *tar.Header, destination string, strip int) error {
+	if header.Typeflag == tar.TypeXGlobalHeader {
+		// Global PAX headers (git archive stores the commit ID in one)
+		// carry defaults for the entries that follow, but archive/tar
+		// doesn't apply them and nothing in them is relevant to
+		// extraction. A global "path" would give every following entry
+		// the same name, so it's not honored either.
+		if verbose && len(header.PAXRecords) > 0 {
+			fmt.Printf(" - global header: %v\n", header.PAXRecords)
+		}
+		return nil
+	}
+
+	// Some producers mark directories as regular files; the trailing
+	// slash is what tells. Creating an empty file by that name would
+	// block the entries inside the directory.
+	if strings.HasSuffix(header.Name, "/") && (header.Typeflag == tar.TypeReg || header.Typeflag == tar.TypeRegA) {
+		dir := *header
+		dir.Typeflag = tar.TypeDir
+		header = &dir
+	}
+
+	if err := checkAbsolute(header.Name); err != nil {
+		return err
+	}
+	if header.Typeflag == tar.TypeLink {
+		if err := checkAbsolute(header.Linkname); err != nil {
+			return err
+		}
+	}
+
+	name, reason := entryName(header.Name, strip)
+	if reason != "" {
+		skipped.add(header.Name, reason)
+		return nil
+	} else if name == "" {
+		return nil
+	}
+	linkname := header.Linkname
+	if header.Typeflag == tar.TypeLink {
+		// Hard links name the entry they link to, which is mapped the
+		// same way; a link to something stripped away goes too.
+		if linkname, reason = entryName(linkname, strip); reason != "" {
+			warn("%s: skipping hard link to %s, which was left out by %s", name, header.Linkname, reason)
+			skipped.add(header.Name, reason)
 			return nil
 		}
-		name = strings.Join(parts[strip:], "/")
 	}
 
-	if name == "" {
+	name, err := checkName(name)
+	if err != nil {
+		return err
+	}
+	if err := checkEscape(name); err != nil {
+		return err
+	}
+	if reason, err := filtered(destination, name, header.Typeflag == tar.TypeDir); reason != "" {
+		skipped.add(name, reason)
+		leftOut[filepath.Join(destination, name)] = true
+		return err
+	}
+
+	if listEntry != nil {
+		listEntry(entryInfo{
+			Index:    index,
+			Name:     name,
+			Size:     header.Size,
+			Mode:     header.FileInfo().Mode(),
+			ModTime:  header.ModTime,
+			Linkname: linkname,
+		})
 		return nil
 	}
 
 	if verbose {
-		fmt.Println(" -", name)
+		isFile := false
+		switch header.Typeflag {
+		case tar.TypeReg, tar.TypeRegA, tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+			isFile = tarOut == nil
+		}
+		printName(name, header.FileInfo().Mode(), isFile)
+	}
+
+	if tarOut != nil {
+		hdr := *header
+		hdr.Linkname = linkname
+		return retarFile(name, hdr, tr)
+	}
+
+	dupe := false
+	if header.Typeflag != tar.TypeDir {
+		dupe = overwritten.see(name)
 	}
 
 	switch header.Typeflag {
 	case tar.TypeDir:
-		return mkdir(filepath.Join(destination, name))
+		fpath := filepath.Join(destination, name)
+		if dryRun {
+			printDryRun(fpath, header.FileInfo().Mode(), false)
+			return nil
+		}
+		if err := mkdir(fpath); err != nil {
+			return err
+		}
+		return restoreXattrs(fpath, header)
 	case tar.TypeReg, tar.TypeRegA, tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
-		return writeNewFile(filepath.Join(destination, name), tr, header.FileInfo().Mode())
+		fpath := filepath.Join(destination, name)
+		if dryRun {
+			printDryRun(fpath, fileMode(header.FileInfo().Mode()), dupe)
+			return nil
+		}
+		if skipExisting && unchanged(fpath, header.Size, header.ModTime) {
+			skipped.add(name, "unchanged")
+			return nil
+		}
+		cr := &readErrReader{r: tr}
+		if err := writeNewFile(fpath, cr, header.FileInfo().Mode()); err != nil {
+			if cr.err != nil {
+				return &corruptError{fmt.Errorf("%s: reading archive: %v", name, cr.err)}
+			}
+			return err
+		}
+		extracted.add(fpath, header.Size, 0, false)
+		if err := setModTime(fpath, header.ModTime); err != nil {
+			return err
+		}
+		return restoreXattrs(fpath, header)
 	case tar.TypeSymlink:
+		if noAbsLinks {
+			if err := checkSymlink(name, header.Linkname); err != nil {
+				return err
+			}
+		}
+		if dryRun {
+			printDryRun(filepath.Join(destination, name)+" -> "+header.Linkname, header.FileInfo().Mode(), dupe)
+			return nil
+		}
 		return writeNewSymbolicLink(filepath.Join(destination, name), header.Linkname)
 	case tar.TypeLink:
-		return writeNewHardLink(filepath.Join(destination, name), filepath.Join(destination, header.Linkname))
+		target, err := checkName(linkname)
+		if err != nil {
+			return err
+		}
+		if err := checkEscape(target); err != nil {
+			return err
+		}
+		if dryRun {
+			printDryRun(filepath.Join(destination, name)+" => "+filepath.Join(destination, target), header.FileInfo().Mode(), dupe)
+			return nil
+		}
+		fpath, tpath := filepath.Join(destination, name), filepath.Join(destination, target)
+		if _, err := os.Lstat(tpath); os.IsNotExist(err) {
+			// The target may come later in the archive.
+			deferredLinks = append(deferredLinks, hardLink{fpath, tpath})
+			return nil
+		}
+		return writeNewHardLink(fpath, tpath)
 	default:
 		return fmt.Errorf("%s: unknown type flag: %c", name, header.Typeflag)
 	}
 }
 
+// restoreXattrs applies the extended attributes recorded in the PAX
+// headers of header to the file at fpath, when requested.
+func restoreXattrs(fpath string, header *tar.Header) error {
+	if !xattrs {
+		return nil
+	}
+	for key, value := range header.PAXRecords {
+		name := strings.TrimPrefix(key, "SCHILY.xattr.")
+		if name == key {
+			continue
+		}
+		if err := setXattr(fpath, name, value); err != nil {
+			return fmt.Errorf("%s: setting extended attribute %s: %v", fpath, name, err)
+		}
+	}
+	return nil
+}
+
+// setModTime gives the file at fpath the modification time mtime, or the
+// clamp time if that's earlier. Unless clamping or skipping existing files
+// (which relies on the times matching), the file is left as is.
+func setModTime(fpath string, mtime time.Time) error {
+	if clampTime.IsZero() && !skipExisting {
+		return nil
+	}
+	mtime = effectiveModTime(mtime)
+	if err := os.Chtimes(fpath, mtime, mtime); err != nil {
+		return fmt.Errorf("%s: setting modification time: %v", fpath, err)
+	}
+	return nil
+}
+
+func effectiveModTime(mtime time.Time) time.Time {
+	if !clampTime.IsZero() && (mtime.IsZero() || mtime.After(clampTime)) {
+		return clampTime
+	}
+	return mtime
+}
+
+// unchanged returns true if there's already a regular file at fpath with
+// the given size and modification time.
+func unchanged(fpath string, size int64, mtime time.Time) bool {
+	fi, err := os.Stat(fpath)
+	if err != nil || !fi.Mode().IsRegular() {
+		return false
+	}
+	if fi.Size() != size || fi.ModTime().Unix() != effectiveModTime(mtime).Unix() {
+		return false
+	}
+	if verbose {
+		fmt.Println("   (unchanged, skipped)")
+	}
+	return true
+}
+
+// printName prints the name of an entry being extracted. For files, the
+// mode is included when it's changed from what the archive says.
+func printName(name string, fm os.FileMode, isFile bool) {
+	if isFile {
+		if final := fileMode(fm); final != fm {
+			fmt.Printf(" - %s (%04o -> %04o)\n", name, fm.Perm(), final.Perm())
+			return
+		}
+	}
+	fmt.Println(" -", name)
+}
+
+// printDryRun prints the would-be destination path of an entry in
+// -dry-run mode.
+func printDryRun(fpath string, fm os.FileMode, dupe bool) {
+	if dupe {
+		fmt.Printf("%v %s (overwrites an earlier entry)\n", fm, fpath)
+		return
+	}
+	fmt.Printf("%v %s\n", fm, fpath)
+}
+
+// fileMode returns the mode given to an extracted file that has mode fm in
+// the archive.
+func fileMode(fm os.FileMode) os.FileMode {
+	if normPerms {
+		if fm&0111 != 0 {
+			fm = 0755
+		} else {
+			fm = 0644
+		}
+	}
+	if noExec {
+		fm &^= 0111
+	}
+	fm &^= umask
+	return fm
+}
+
+// readErrReader remembers the last read error other than io.EOF.
+type readErrReader struct {
+	r   io.Reader
+	err error
+}
+
+func (r *readErrReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+	return n, err
+}
+
 func writeNewFile(fpath string, in io.Reader, fm os.FileMode) error {
-	err := os.MkdirAll(filepath.Dir(fpath), 0755)
+	fm = fileMode(fm)
+
+	err := mkdirAll(filepath.Dir(fpath))
 	if err != nil {
 		return fmt.Errorf("%s: making directory for file: %v", fpath, err)
 	}
 
+	if atomic {
+		return writeNewFileAtomic(fpath, in, fm)
+	}
+
 	out, err := os.Create(fpath)
 	if err != nil {
 		return fmt.Errorf("%s: creating new file: %v", fpath, err)
@@ -202,15 +1928,95 @@ func writeNewFile(fpath string, in io.Reader, fm os.FileMode) error {
 		return fmt.Errorf("%s: changing file mode: %v", fpath, err)
 	}
 
-	_, err = io.Copy(out, in)
+	_, err = copyBuffer(out, in)
+	if err != nil {
+		return fmt.Errorf("%s: writing file: %v", fpath, err)
+	}
+	return nil
+}
+
+// copyBuffer copies from in to out through a buffer of -buffer-size bytes.
+// The file is hidden behind a plain io.Writer, because its ReadFrom would
+// otherwise be used with a buffer of its own choosing.
+func copyBuffer(out *os.File, in io.Reader) (int64, error) {
+	return io.CopyBuffer(struct{ io.Writer }{out}, in, make([]byte, bufferSize))
+}
+
+// writeNewFileAtomic writes to a temporary file next to fpath and renames it
+// into place when complete, so that concurrent readers never observe a
+// partially written file. This costs an extra rename per file, plus an
+// unlink of the temporary on failure.
+func writeNewFileAtomic(fpath string, in io.Reader, fm os.FileMode) error {
+	out, err := os.CreateTemp(filepath.Dir(fpath), "."+filepath.Base(fpath)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("%s: creating temporary file: %v", fpath, err)
+	}
+	tmp := out.Name()
+
+	err = out.Chmod(fm)
+	if err != nil && runtime.GOOS != "windows" {
+		out.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("%s: changing file mode: %v", fpath, err)
+	}
+
+	_, err = copyBuffer(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
 	if err != nil {
+		os.Remove(tmp)
 		return fmt.Errorf("%s: writing file: %v", fpath, err)
 	}
+
+	if err := os.Rename(tmp, fpath); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("%s: moving file into place: %v", fpath, err)
+	}
+	return nil
+}
+
+// checkAbsolute returns an error for absolute entry names, with a leading
+// slash or a drive letter, with -abort-on-absolute-path. Otherwise they're
+// extracted relative to the destination like any other.
+func checkAbsolute(name string) error {
+	if !abortOnAbs {
+		return nil
+	}
+	slashed := strings.ReplaceAll(name, `\`, "/")
+	drive := len(slashed) >= 2 && slashed[1] == ':' && (slashed[0]|0x20 >= 'a' && slashed[0]|0x20 <= 'z')
+	if strings.HasPrefix(slashed, "/") || drive {
+		return fmt.Errorf("%s: absolute path in archive (-abort-on-absolute-path)", name)
+	}
+	return nil
+}
+
+// checkEscape returns an error if the entry name would end up outside of
+// the destination once joined with it.
+func checkEscape(name string) error {
+	clean := path.Clean(filepath.ToSlash(name))
+	if clean == ".." || strings.HasPrefix(clean, "../") {
+		return fmt.Errorf("%s: path is outside the destination", name)
+	}
+	return nil
+}
+
+// checkSymlink returns an error if the symlink entry name points at an
+// absolute path or, once resolved, outside of the destination.
+func checkSymlink(name, target string) error {
+	slashed := filepath.ToSlash(target)
+	if filepath.IsAbs(target) || path.IsAbs(slashed) || filepath.VolumeName(target) != "" {
+		return fmt.Errorf("%s: symlink to absolute path %s", name, target)
+	}
+	resolved := path.Join(path.Dir(name), slashed)
+	if resolved == ".." || strings.HasPrefix(resolved, "../") {
+		return fmt.Errorf("%s: symlink target %s is outside the destination", name, target)
+	}
 	return nil
 }
 
 func writeNewSymbolicLink(fpath string, target string) error {
-	err := os.MkdirAll(filepath.Dir(fpath), 0755)
+	err := mkdirAll(filepath.Dir(fpath))
 	if err != nil {
 		return fmt.Errorf("%s: making directory for file: %v", fpath, err)
 	}
@@ -223,13 +2029,50 @@ func writeNewSymbolicLink(fpath string, target string) error {
 	return nil
 }
 
+// deferredLinks are the hard links in a tar archive that come before
+// their targets, to be created once everything else is extracted.
+var deferredLinks []hardLink
+
+// leftOut holds the destination paths of the tar entries left out by
+// -index or the filters, so that links to them are told apart from links
+// to entries missing from the archive.
+var leftOut map[string]bool
+
+type hardLink struct {
+	fpath, target string
+}
+
+// createDeferredLinks creates the deferred hard links, returning an error
+// for each that failed. Links to entries that were left out are skipped
+// with a warning, as links to stripped entries are.
+func createDeferredLinks() []error {
+	var errs []error
+	for _, link := range deferredLinks {
+		if _, err := os.Lstat(link.target); os.IsNotExist(err) && leftOut[link.target] {
+			warn("%s: skipping hard link to %s, which was left out", link.fpath, link.target)
+			continue
+		} else if os.IsNotExist(err) {
+			errs = append(errs, fmt.Errorf("%s: hard link target %s is not in the archive", link.fpath, link.target))
+			continue
+		}
+		if err := writeNewHardLink(link.fpath, link.target); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	deferredLinks = nil
+	return errs
+}
+
 func writeNewHardLink(fpath string, target string) error {
-	err := os.MkdirAll(filepath.Dir(fpath), 0755)
+	err := mkdirAll(filepath.Dir(fpath))
 	if err != nil {
 		return fmt.Errorf("%s: making directory for file: %v", fpath, err)
 	}
 
 	err = os.Link(target, fpath)
+	if err != nil && hardlinkFallback == "copy" && linkUnsupported(err) {
+		return copyForLink(fpath, target, err)
+	}
 	if err != nil {
 		return fmt.Errorf("%s: making hard link for: %v", fpath, err)
 	}
@@ -237,8 +2080,51 @@ func writeNewHardLink(fpath string, target string) error {
 	return nil
 }
 
+// hardlinkFallback is "copy" to write a copy of the target where a hard
+// link can't be made, or empty to fail.
+var (
+	hardlinkFallback = ""
+	warnLinkCopy     sync.Once
+)
+
+// linkUnsupported returns true if err means hard links can't be made
+// here at all, as on some FUSE and FAT file systems, rather than that
+// this one failed.
+func linkUnsupported(err error) bool {
+	return errors.Is(err, errors.ErrUnsupported) || errors.Is(err, syscall.EPERM) || errors.Is(err, syscall.EXDEV)
+}
+
+func copyForLink(fpath, target string, linkErr error) error {
+	warnLinkCopy.Do(func() {
+		warn("can't make hard links (%v); copying the files instead", errors.Unwrap(linkErr))
+	})
+	if verbose {
+		fmt.Printf("   (copying %s instead of linking)\n", target)
+	}
+	fd, err := os.Open(target)
+	if err != nil {
+		return fmt.Errorf("%s: copying hard link target: %v", fpath, err)
+	}
+	defer fd.Close()
+	fi, err := fd.Stat()
+	if err != nil {
+		return fmt.Errorf("%s: copying hard link target: %v", fpath, err)
+	}
+	return writeNewFile(fpath, fd, fi.Mode())
+}
+
+// mkdirMut serializes directory creation between parallel extraction
+// workers.
+var mkdirMut sync.Mutex
+
+func mkdirAll(dirPath string) error {
+	mkdirMut.Lock()
+	defer mkdirMut.Unlock()
+	return os.MkdirAll(dirPath, 0755)
+}
+
 func mkdir(dirPath string) error {
-	err := os.MkdirAll(dirPath, 0755)
+	err := mkdirAll(dirPath)
 	if err != nil {
 		return fmt.Errorf("%s: making directory: %v", dirPath, err)
 	}
@@ -0,0 +1,477 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// tarEntry is an entry for tarData; a name ending in a slash without a type
+// is a directory.
+type tarEntry struct {
+	name     string
+	typ      byte
+	linkname string
+	body     string
+}
+
+func tarData(t testing.TB, entries ...tarEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Typeflag: e.typ, Linkname: e.linkname, Mode: 0644, Size: int64(len(e.body))}
+		if hdr.Typeflag == 0 {
+			hdr.Typeflag = tar.TypeReg
+			if strings.HasSuffix(e.name, "/") {
+				hdr.Typeflag = tar.TypeDir
+			}
+		}
+		if hdr.Typeflag == tar.TypeDir {
+			hdr.Mode = 0755
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// renameTarEntry renames the entry old in the tar data to new, of the same
+// length, in place, bypassing the checks of archive/tar.
+func renameTarEntry(data []byte, old, new string) {
+	for off := 0; off+512 <= len(data); off += 512 {
+		block := data[off : off+512]
+		if !bytes.HasPrefix(block, []byte(old+"\x00")) {
+			continue
+		}
+		copy(block, new)
+		// The checksum is the sum of the header bytes, counting its own
+		// field as spaces.
+		copy(block[148:156], "        ")
+		sum := 0
+		for _, b := range block {
+			sum += int(b)
+		}
+		copy(block[148:156], fmt.Sprintf("%06o\x00 ", sum))
+	}
+}
+
+// zipData returns a zip of the given names and contents, in order; names
+// ending in a slash are directories.
+func zipData(t *testing.T, files ...string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for i := 0; i+1 < len(files); i += 2 {
+		w, err := zw.Create(files[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(files[i+1])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// extractData extracts the archive data into a new directory, as dl
+// extract does, and returns the directory.
+func extractData(t *testing.T, data []byte, strip int) (string, error) {
+	t.Helper()
+	src := filepath.Join(t.TempDir(), "archive")
+	if err := os.WriteFile(src, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	localSource = true
+	defer func() { localSource = false }()
+	return downloadTest(context.Background(), t, src, strip)
+}
+
+// downloadTest downloads and extracts url into a new directory, returning
+// the directory. Any warnings are left in warnings.
+func downloadTest(ctx context.Context, t *testing.T, url string, strip int) (string, error) {
+	t.Helper()
+	if client == nil {
+		client = newClient()
+	}
+	// Starting afresh, leaving what this run did for the caller to see.
+	skipped.reset()
+	overwritten.reset()
+	warnMut.Lock()
+	warnings = nil
+	warnMut.Unlock()
+	dst := filepath.Join(t.TempDir(), "dst")
+	return dst, download(ctx, url, dst, strip)
+}
+
+// listFiles returns the slash separated names of everything under dir, with
+// a trailing slash for directories and "-> target" for symlinks.
+func listFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	var names []string
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil || p == dir {
+			return err
+		}
+		name := filepath.ToSlash(p[len(dir)+1:])
+		switch {
+		case info.IsDir():
+			name += "/"
+		case info.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			name += " -> " + target
+		}
+		names = append(names, name)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+func TestDeepTree(t *testing.T) {
+	// Well past the 260 characters of MAX_PATH on Windows.
+	deep := strings.Repeat("abcdefghij/", 40)
+	data := tarData(t,
+		tarEntry{name: deep},
+		tarEntry{name: deep + "file.txt", body: "deep"},
+	)
+	dst, err := extractData(t, data, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(longPath(filepath.Join(dst, filepath.FromSlash(deep), "file.txt")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "deep" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestTimeLimit(t *testing.T) {
+	data := tarData(t, tarEntry{name: "big", body: strings.Repeat("x", 1<<20)})
+	// Slow but never stalled, so no per-read timeout would trigger.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < len(data); i += 512 {
+			if _, err := w.Write(data[i : i+512]); err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+			time.Sleep(10 * time.Millisecond)
+		}
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	t0 := time.Now()
+	_, err := downloadTest(ctx, t, srv.URL+"/a.tar", 0)
+	if err == nil {
+		t.Fatal("download succeeded")
+	}
+	if ctx.Err() != context.DeadlineExceeded {
+		t.Errorf("failed before the deadline: %v", err)
+	}
+	if d := time.Since(t0); d > 5*time.Second {
+		t.Errorf("took %v to give up", d)
+	}
+}
+
+func TestGlobalPAXHeader(t *testing.T) {
+	cases := []struct {
+		name    string
+		records map[string]string
+	}{
+		{"git archive", map[string]string{"comment": "0123456789abcdef0123456789abcdef01234567"}},
+		{"global path", map[string]string{"path": "prefix/"}},
+		{"global mtime", map[string]string{"mtime": "1234567890"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			tw := tar.NewWriter(&buf)
+			if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeXGlobalHeader, Name: "pax_global_header", PAXRecords: tc.records}); err != nil {
+				t.Fatal(err)
+			}
+			tw.WriteHeader(&tar.Header{Name: "top/", Typeflag: tar.TypeDir, Mode: 0755})
+			tw.WriteHeader(&tar.Header{Name: "top/file", Typeflag: tar.TypeReg, Mode: 0644, Size: 2})
+			tw.Write([]byte("hi"))
+			tw.Close()
+
+			dst, err := extractData(t, buf.Bytes(), 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := strings.Join(listFiles(t, dst), " "), "top/ top/file"; got != want {
+				t.Errorf("extracted %q, expected %q", got, want)
+			}
+		})
+	}
+}
+
+func TestZipDirectoriesStripped(t *testing.T) {
+	cases := []struct {
+		name  string
+		files []string
+		strip int
+		want  string
+	}{
+		{"no strip", []string{"top/", "", "top/sub/", ""}, 0, "top/ top/sub/"},
+		{"strip one", []string{"top/", "", "top/sub/", "", "top/sub/deeper/", ""}, 1, "sub/ sub/deeper/"},
+		{"strip all", []string{"top/", "", "top/sub/", ""}, 2, ""},
+		{"dirs and files", []string{"top/sub/", "", "top/sub/file", "data", "top/empty/", ""}, 1, "empty/ sub/ sub/file"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			dst, err := extractData(t, zipData(t, tc.files...), tc.strip)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			if _, err := os.Stat(dst); err == nil {
+				got = listFiles(t, dst)
+			}
+			if strings.Join(got, " ") != tc.want {
+				t.Errorf("extracted %q, expected %q", strings.Join(got, " "), tc.want)
+			}
+		})
+	}
+}
+
+func TestCheckSymlink(t *testing.T) {
+	cases := []struct {
+		name, target string
+		ok           bool
+	}{
+		{"link", "/etc/shadow", false},
+		{"dir/link", "/etc/shadow", false},
+		{"link", "target", true},
+		{"dir/link", "../target", true},
+		{"dir/link", "../../target", false},
+		{"link", "..", false},
+		{"dir/sub/link", "../../x/../y", true},
+		{"link", "./././target", true},
+	}
+	for _, tc := range cases {
+		if err := checkSymlink(tc.name, tc.target); (err == nil) != tc.ok {
+			t.Errorf("checkSymlink(%q, %q) = %v, expected ok %v", tc.name, tc.target, err, tc.ok)
+		}
+	}
+}
+
+func TestCheckEscape(t *testing.T) {
+	cases := []struct {
+		name string
+		ok   bool
+	}{
+		{"file", true},
+		{"dir/../file", true},
+		{"..", false},
+		{"../file", false},
+		{"dir/../../file", false},
+		{"./../file", false},
+		{"..file", true},
+	}
+	for _, tc := range cases {
+		if err := checkEscape(tc.name); (err == nil) != tc.ok {
+			t.Errorf("checkEscape(%q) = %v, expected ok %v", tc.name, err, tc.ok)
+		}
+	}
+}
+
+func TestRejectedEntries(t *testing.T) {
+	cases := []struct {
+		name       string
+		entry      tarEntry
+		noAbsLinks bool
+		ok         bool
+	}{
+		{"absolute symlink", tarEntry{name: "shadow", typ: tar.TypeSymlink, linkname: "/etc/shadow"}, true, false},
+		{"absolute symlink allowed", tarEntry{name: "shadow", typ: tar.TypeSymlink, linkname: "/etc/shadow"}, false, true},
+		{"escaping symlink", tarEntry{name: "dir/link", typ: tar.TypeSymlink, linkname: "../../outside"}, true, false},
+		{"relative symlink", tarEntry{name: "dir/link", typ: tar.TypeSymlink, linkname: "../inside"}, true, true},
+		{"traversal", tarEntry{name: "../evil", body: "x"}, false, false},
+		{"hard link out", tarEntry{name: "link", typ: tar.TypeLink, linkname: "../../etc/passwd"}, false, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if runtime.GOOS == "windows" && tc.ok && tc.entry.typ == tar.TypeSymlink {
+				t.Skip("creating symlinks may need privileges on Windows")
+			}
+			noAbsLinks = tc.noAbsLinks
+			defer func() { noAbsLinks = false }()
+			dst, err := extractData(t, tarData(t, tc.entry), 0)
+			if (err == nil) != tc.ok {
+				t.Fatalf("extraction error %v, expected ok %v", err, tc.ok)
+			}
+			if _, err := os.Lstat(filepath.Join(dst, "..", "evil")); err == nil {
+				t.Error("wrote outside the destination")
+			}
+		})
+	}
+}
+
+func BenchmarkBufferSize(b *testing.B) {
+	data := tarData(b, tarEntry{name: "large", body: strings.Repeat("0123456789abcdef", 2<<20)})
+	defer func(size int) { bufferSize = size }(bufferSize)
+	for _, size := range []int{4 << 10, 32 << 10, 256 << 10, 1 << 20} {
+		b.Run(fmt.Sprintf("%dk", size>>10), func(b *testing.B) {
+			bufferSize = size
+			dst := b.TempDir()
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				if err := untar(context.Background(), bytes.NewReader(data), dst, 0); err != nil {
+					b.Fatal(err)
+				}
+				overwritten.reset()
+			}
+		})
+	}
+}
+
+func TestTrailingSlashEntries(t *testing.T) {
+	cases := []struct {
+		name    string
+		entries []tarEntry
+		want    string
+	}{
+		// archive/tar won't write these, so the names are fixed up below.
+		{"regular file with slash", []tarEntry{
+			{name: "dir#", typ: tar.TypeReg},
+			{name: "dir/file", body: "x"},
+		}, "dir/ dir/file"},
+		{"old style regular file with slash", []tarEntry{
+			{name: "dir#", typ: tar.TypeRegA},
+			{name: "dir/sub#", typ: tar.TypeReg},
+			{name: "dir/sub/file", body: "x"},
+		}, "dir/ dir/sub/ dir/sub/file"},
+		{"directory without slash", []tarEntry{
+			{name: "dir", typ: tar.TypeDir},
+			{name: "dir/file", body: "x"},
+		}, "dir/ dir/file"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			data := tarData(t, tc.entries...)
+			for _, e := range tc.entries {
+				if strings.HasSuffix(e.name, "#") {
+					renameTarEntry(data, e.name, strings.TrimSuffix(e.name, "#")+"/")
+				}
+			}
+			dst, err := extractData(t, data, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.Join(listFiles(t, dst), " "); got != tc.want {
+				t.Errorf("extracted %q, expected %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestOutOfOrderLinks(t *testing.T) {
+	cases := []struct {
+		name     string
+		entries  []tarEntry
+		exclude  string
+		index    []int
+		strip    int
+		want     string
+		warnings int
+		wantErr  string
+	}{
+		{"hard link first", []tarEntry{
+			{name: "d/link", typ: tar.TypeLink, linkname: "d/target"},
+			{name: "d/target", body: "abc"},
+		}, "", nil, 0, "d/ d/link d/target", 0, ""},
+		{"symlink first", []tarEntry{
+			{name: "d/sym", typ: tar.TypeSymlink, linkname: "target"},
+			{name: "d/target", body: "abc"},
+		}, "", nil, 0, "d/ d/sym -> target d/target", 0, ""},
+		{"target excluded", []tarEntry{
+			{name: "d/link", typ: tar.TypeLink, linkname: "d/target"},
+			{name: "d/target", body: "abc"},
+			{name: "d/other", body: "abc"},
+		}, "target", nil, 0, "d/ d/other", 1, ""},
+		{"target not indexed", []tarEntry{
+			{name: "d/link", typ: tar.TypeLink, linkname: "d/target"},
+			{name: "d/target", body: "abc"},
+		}, "", []int{0}, 0, "", 1, ""},
+		{"target stripped", []tarEntry{
+			{name: "d/link", typ: tar.TypeLink, linkname: "target"},
+			{name: "target", body: "abc"},
+		}, "", nil, 1, "", 1, ""},
+		{"target missing", []tarEntry{
+			{name: "d/link", typ: tar.TypeLink, linkname: "d/target"},
+		}, "", nil, 0, "", 0, "is not in the archive"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if runtime.GOOS == "windows" && strings.Contains(tc.want, " -> ") {
+				t.Skip("creating symlinks may need privileges on Windows")
+			}
+			if tc.exclude != "" {
+				excludes.Set(tc.exclude)
+			}
+			for _, i := range tc.index {
+				indices[i] = true
+			}
+			defer func() {
+				*excludes = nil
+				indices = indexSet{}
+			}()
+
+			dst, err := extractData(t, tarData(t, tc.entries...), tc.strip)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("error %v, expected %q", err, tc.wantErr)
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			if _, err := os.Stat(dst); err == nil {
+				got = listFiles(t, dst)
+			}
+			if strings.Join(got, " ") != tc.want {
+				t.Errorf("extracted %q, expected %q", strings.Join(got, " "), tc.want)
+			}
+			if len(warnings) != tc.warnings {
+				t.Errorf("warnings %q, expected %d", warnings, tc.warnings)
+			}
+			if strings.Contains(tc.want, "d/link") {
+				link, _ := os.Stat(filepath.Join(dst, "d", "link"))
+				target, _ := os.Stat(filepath.Join(dst, "d", "target"))
+				if link == nil || target == nil || !os.SameFile(link, target) {
+					t.Error("d/link isn't a hard link to d/target")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// checkName verifies that every component of the slash separated entry
+// name can be created on Windows. When sanitizing, offending components are
+// rewritten instead and the fixed name is returned.
+func checkName(name string) (string, error) {
+	parts := strings.Split(name, "/")
+	for i, part := range parts {
+		if part == "" || part == "." || part == ".." {
+			continue
+		}
+		fixed, problem := fixComponent(part)
+		if problem == "" {
+			continue
+		}
+		if !sanitize {
+			return "", fmt.Errorf("%s: not a valid file name on Windows: %s (use -sanitize to replace)", name, problem)
+		}
+		parts[i] = fixed
+	}
+	return strings.Join(parts, "/"), nil
+}
+
+// fixComponent returns a version of the single path component part that is
+// acceptable to Windows, and a description of what was wrong with it. The
+// description is empty if part was fine to begin with.
+func fixComponent(part string) (string, string) {
+	var problem string
+
+	fixed := strings.Map(func(r rune) rune {
+		if r < 32 || strings.ContainsRune(`<>:"|?*`, r) {
+			problem = fmt.Sprintf("contains %q", r)
+			return '_'
+		}
+		return r
+	}, part)
+
+	// Windows silently drops trailing dots and spaces, which would make
+	// the file end up with a different name than in the archive.
+	if trimmed := strings.TrimRight(fixed, ". "); trimmed != fixed {
+		problem = "ends with a dot or space"
+		fixed = trimmed + strings.Repeat("_", len(fixed)-len(trimmed))
+	}
+
+	// Reserved device names are reserved regardless of extension.
+	base := fixed
+	if i := strings.IndexByte(base, '.'); i >= 0 {
+		base = base[:i]
+	}
+	if reservedNames[strings.ToUpper(strings.TrimRight(base, " "))] {
+		problem = fmt.Sprintf("%s is a reserved name", base)
+		fixed = base + "_" + fixed[len(base):]
+	}
+
+	return fixed, problem
+}
+
+// longPath returns p as an absolute, extended-length path so that files
+// deep inside it aren't subject to the 260 character MAX_PATH limit. The
+// `\\?\` prefix disables all path normalization in the Windows API, so the
+// path is cleaned and uses backslashes only; everything later joined onto it
+// goes through filepath.Join and stays that way.
+func longPath(p string) string {
+	if strings.HasPrefix(p, `\\?\`) {
+		return p
+	}
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return p
+	}
+	if strings.HasPrefix(abs, `\\`) {
+		// UNC path, \\server\share\...
+		return `\\?\UNC\` + abs[2:]
+	}
+	return `\\?\` + abs
+}
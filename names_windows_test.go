@@ -0,0 +1,20 @@
+package main
+
+import "testing"
+
+func TestLongPath(t *testing.T) {
+	cases := []struct {
+		in, want string
+	}{
+		{`C:\dl\dst`, `\\?\C:\dl\dst`},
+		{`C:/dl/dst`, `\\?\C:\dl\dst`},
+		{`C:\dl\..\dst`, `\\?\C:\dst`},
+		{`\\server\share\dst`, `\\?\UNC\server\share\dst`},
+		{`\\?\C:\dl\dst`, `\\?\C:\dl\dst`},
+	}
+	for _, tc := range cases {
+		if got := longPath(tc.in); got != tc.want {
+			t.Errorf("longPath(%q) = %q, expected %q", tc.in, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// archiveExts are the extensions removed from the file name of the URL to
+// name the destination, compound ones first. Only one is removed, so dots
+// elsewhere in the name, as in myapp-1.2.3.tar.gz, are kept.
+var archiveExts = []string{
+	".tar.gz", ".tar.bz2", ".tar.xz", ".tar.zst", ".tar.lz", ".tar.lzma", ".cpio.gz",
+	".tgz", ".tbz2", ".tbz", ".txz", ".tzst",
+	".tar", ".zip", ".cpio", ".gz", ".bz2", ".xz", ".zst", ".lz",
+	".jar", ".apk", ".ipa",
+}
+
+// urlFileName returns the file name in the path of src, leaving out any
+// query string, as in https://host/myapp-1.2.3.tar.gz?raw=1, since that's
+// not part of the name.
+func urlFileName(src string) string {
+	if localSource {
+		return filepath.Base(src)
+	}
+	if u, err := url.Parse(src); err == nil && u.Scheme != "" {
+		if name := path.Base(u.Path); name != "." && name != "/" {
+			return name
+		}
+		return u.Host
+	}
+	return path.Base(src)
+}
+
+// trimArchiveExt returns name without its archive extension, if it has a
+// known one, along with the extension.
+func trimArchiveExt(name string) (string, string) {
+	lower := strings.ToLower(name)
+	for _, ext := range archiveExts {
+		if strings.HasSuffix(lower, ext) && len(name) > len(ext) {
+			return name[:len(name)-len(ext)], name[len(name)-len(ext):]
+		}
+	}
+	return name, ""
+}
+
+// nameData is what a -name-template can refer to.
+type nameData struct {
+	File    string // the file name of the URL, as is
+	Name    string // the file name without its archive extension
+	Ext     string // the archive extension, with the dot
+	Version string // the version number in the file name, if any
+}
+
+// parseNameTemplate parses a -name-template, checking it against an
+// example name.
+func parseNameTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("name").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := executeName(tmpl, "example-1.2.3.tar.gz"); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// defaultName returns the name for the destination of the archive with the
+// given file name, from tmpl if set.
+func defaultName(tmpl *template.Template, file string) (string, error) {
+	if tmpl == nil {
+		name, _ := trimArchiveExt(file)
+		return name, nil
+	}
+	return executeName(tmpl, file)
+}
+
+func executeName(tmpl *template.Template, file string) (string, error) {
+	data := nameData{File: file, Version: versionPattern.FindString(file)}
+	data.Name, data.Ext = trimArchiveExt(file)
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+	name := b.String()
+	if name == "" || name == "." || name == ".." || filepath.IsAbs(name) {
+		return "", fmt.Errorf("template gives unusable name %q", name)
+	}
+	return name, nil
+}
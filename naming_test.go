@@ -0,0 +1,52 @@
+package main
+
+import "testing"
+
+func TestDefaultName(t *testing.T) {
+	cases := []struct {
+		url, want string
+	}{
+		{"https://example.com/myapp-1.2.3.tar.gz", "myapp-1.2.3"},
+		{"https://example.com/myapp-1.2.3.TAR.GZ", "myapp-1.2.3"},
+		{"https://example.com/myapp-1.2.3.tgz", "myapp-1.2.3"},
+		{"https://example.com/myapp_v2.0.1-linux-amd64.tar.xz", "myapp_v2.0.1-linux-amd64"},
+		{"https://example.com/myapp-1.2.3.zip?raw=1", "myapp-1.2.3"},
+		{"https://example.com/dl/myapp-1.2.3.tar.gz#sha256=abc", "myapp-1.2.3"},
+		{"https://example.com/myapp-1.2.3", "myapp-1.2.3"},
+		{"https://example.com/myapp.1.tar.zst", "myapp.1"},
+		{"https://example.com/archive.tar", "archive"},
+		{"https://example.com/", "example.com"},
+	}
+	for _, tc := range cases {
+		got, err := defaultName(nil, urlFileName(tc.url))
+		if err != nil {
+			t.Errorf("%s: %v", tc.url, err)
+		} else if got != tc.want {
+			t.Errorf("%s: got %q, expected %q", tc.url, got, tc.want)
+		}
+	}
+}
+
+func TestNameTemplate(t *testing.T) {
+	cases := []struct {
+		tmpl, file, want string
+		wantErr          bool
+	}{
+		{"{{.Name}}", "myapp-1.2.3.tar.gz", "myapp-1.2.3", false},
+		{"app-{{.Version}}", "myapp-1.2.3.tar.gz", "app-1.2.3", false},
+		{"{{.File}}.d", "myapp-1.2.3.tar.gz", "myapp-1.2.3.tar.gz.d", false},
+		{"{{.Name}}{{.Ext}}", "myapp-1.2.3.tgz", "myapp-1.2.3.tgz", false},
+		{"{{.Missing}}", "myapp-1.2.3.tar.gz", "", true},
+		{"/abs", "myapp-1.2.3.tar.gz", "", true},
+	}
+	for _, tc := range cases {
+		tmpl, err := parseNameTemplate(tc.tmpl)
+		var got string
+		if err == nil {
+			got, err = defaultName(tmpl, tc.file)
+		}
+		if (err != nil) != tc.wantErr || got != tc.want {
+			t.Errorf("%s on %s: got %q, %v, expected %q", tc.tmpl, tc.file, got, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// parseOwner resolves a -chown "user:group" to numeric ids. Either part
+// may be given as a number instead of a name, and the group may be left
+// out to keep the group of the files.
+func parseOwner(spec string) (uid, gid int, err error) {
+	userName, groupName, _ := strings.Cut(spec, ":")
+
+	uid = -1
+	if userName != "" {
+		if uid, err = strconv.Atoi(userName); err != nil {
+			u, err := user.Lookup(userName)
+			if err != nil {
+				return 0, 0, err
+			}
+			uid, _ = strconv.Atoi(u.Uid)
+		}
+	}
+
+	gid = -1
+	if groupName != "" {
+		if gid, err = strconv.Atoi(groupName); err != nil {
+			g, err := user.LookupGroup(groupName)
+			if err != nil {
+				return 0, 0, err
+			}
+			gid, _ = strconv.Atoi(g.Gid)
+		}
+	}
+	return uid, gid, nil
+}
+
+// chownTree gives everything under root, root included, the owner uid and
+// group gid. Symlinks themselves are changed, not what they point to.
+func chownTree(root string, uid, gid int) error {
+	return filepath.Walk(root, func(path string, _ os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := os.Lchown(path, uid, gid); err != nil {
+			return fmt.Errorf("%s: changing owner: %v", path, err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+)
+
+// postCmdEnv is the environment variable holding the absolute destination
+// path for the -post-cmd.
+const postCmdEnv = "DL_DESTINATION_DIR"
+
+// runPostCmd runs command, through the shell, in the destination dir once
+// it's in place. It returns the command's exit code, which is 1 if it
+// couldn't be run at all, along with the error.
+func runPostCmd(command, dir string) (int, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return 1, err
+	}
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("/bin/sh", "-c", command)
+	}
+	cmd.Dir = abs
+	cmd.Env = append(os.Environ(), postCmdEnv+"="+abs)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	err = cmd.Run()
+	var ee *exec.ExitError
+	if errors.As(err, &ee) && ee.ExitCode() > 0 {
+		return ee.ExitCode(), err
+	} else if err != nil {
+		return 1, err
+	}
+	return 0, nil
+}
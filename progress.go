@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// meter, when set, tracks the progress of all downloads in the invocation
+// and renders a single combined progress line for them.
+var meter *progressMeter
+
+type progressMeter struct {
+	mut     sync.Mutex
+	done    int64 // bytes read so far, over all downloads
+	total   int64 // sum of the known sizes
+	unknown int   // number of downloads of unknown size
+
+	tty   bool
+	start time.Time
+	stop  chan struct{}
+	wg    sync.WaitGroup
+}
+
+func newProgressMeter() *progressMeter {
+	return &progressMeter{
+		tty:   isTerminal(os.Stderr),
+		start: time.Now(),
+		stop:  make(chan struct{}),
+	}
+}
+
+// track returns a reader that counts what's read from r towards the
+// progress. The size is the expected length of r, or negative if unknown.
+func (m *progressMeter) track(r io.ReadCloser, size int64) io.ReadCloser {
+	if m == nil {
+		return r
+	}
+	m.mut.Lock()
+	if size < 0 {
+		m.unknown++
+	} else {
+		m.total += size
+	}
+	m.mut.Unlock()
+	return &countingReader{ReadCloser: r, meter: m}
+}
+
+// run renders progress until close is called. On a terminal the line is
+// updated in place several times a second; otherwise a summary is printed
+// every few seconds so as not to flood logs.
+func (m *progressMeter) run() {
+	interval := 5 * time.Second
+	if m.tty {
+		interval = 200 * time.Millisecond
+	}
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				m.render(false)
+			case <-m.stop:
+				m.render(true)
+				return
+			}
+		}
+	}()
+}
+
+func (m *progressMeter) close() {
+	close(m.stop)
+	m.wg.Wait()
+}
+
+func (m *progressMeter) render(final bool) {
+	line := m.line()
+	switch {
+	case m.tty && final:
+		fmt.Fprintf(os.Stderr, "\r\033[K%s\n", line)
+	case m.tty:
+		fmt.Fprintf(os.Stderr, "\r\033[K%s", line)
+	default:
+		fmt.Fprintln(os.Stderr, line)
+	}
+}
+
+// line returns the progress so far, such as "1.2 MiB / 3.4 MiB (35%), 600
+// KiB/s".
+func (m *progressMeter) line() string {
+	m.mut.Lock()
+	done, total, unknown := m.done, m.total, m.unknown
+	m.mut.Unlock()
+
+	line := formatBytes(done)
+	// A percentage is only meaningful when the size of every download
+	// is known.
+	if unknown == 0 && total > 0 {
+		line = fmt.Sprintf("%s / %s (%d%%)", line, formatBytes(total), done*100/total)
+	}
+	if secs := time.Since(m.start).Seconds(); secs > 0 {
+		line = fmt.Sprintf("%s, %s/s", line, formatBytes(int64(float64(done)/secs)))
+	}
+	return line
+}
+
+// isTerminal returns true if f is a terminal, or at least a character
+// device other than the null device.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	null, err := os.Stat(os.DevNull)
+	return err != nil || !os.SameFile(fi, null)
+}
+
+type countingReader struct {
+	io.ReadCloser
+	meter *progressMeter
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.meter.mut.Lock()
+	r.meter.done += int64(n)
+	r.meter.mut.Unlock()
+	return n, err
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
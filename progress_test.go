@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestUnknownLength(t *testing.T) {
+	data := tarData(t, tarEntry{name: "file", body: strings.Repeat("x", 100<<10)})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("length") != "" {
+			w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		}
+		// Writing in flushed pieces makes the response chunked, unless
+		// the length was set.
+		for i := 0; i < len(data); i += 4096 {
+			w.Write(data[i:min(i+4096, len(data))])
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer srv.Close()
+
+	cases := []struct {
+		name    string
+		query   string
+		maxSize int64
+		percent bool
+		wantErr string
+	}{
+		{"chunked", "", 0, false, ""},
+		{"with length", "?length=1", 0, true, ""},
+		{"chunked under max-size", "", int64(len(data)), false, ""},
+		{"chunked over max-size", "", 50 << 10, false, "larger than the -max-size"},
+		{"with length over max-size", "?length=1", 50 << 10, false, "larger than the -max-size"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			meter, maxSize = newProgressMeter(), tc.maxSize
+			defer func() { meter, maxSize = nil, 0 }()
+
+			_, err := downloadTest(context.Background(), t, srv.URL+"/a.tar"+tc.query, 0)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("error %v, expected %q", err, tc.wantErr)
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			line := meter.line()
+			if got := strings.Contains(line, "%"); got != tc.percent {
+				t.Errorf("progress %q, expected a percentage: %v", line, tc.percent)
+			}
+			if !strings.HasPrefix(line, formatBytes(int64(len(data)))) {
+				t.Errorf("progress %q, expected %s done", line, formatBytes(int64(len(data))))
+			}
+		})
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// remoteMember is the -remote-member to extract from a remote zip, fetching
+// only the parts of the archive needed for it.
+var remoteMember = ""
+
+const (
+	// rangeChunkSize is the unit fetched and cached by range requests,
+	// since archive/zip does many small reads of the central directory.
+	rangeChunkSize = 64 << 10
+
+	// rangeCacheChunks is the number of chunks kept, least recently used
+	// first out.
+	rangeCacheChunks = 16
+)
+
+// extractRemoteMember extracts the -remote-member entry of the zip at url
+// into destination, using range requests for the central directory and the
+// entry itself instead of downloading the whole archive.
+func extractRemoteMember(ctx context.Context, url, destination string, strip int) error {
+	ra, err := newHTTPReaderAt(ctx, client, url)
+	if err != nil {
+		return err
+	}
+	zr, err := zip.NewReader(ra, ra.size)
+	if err != nil {
+		return fmt.Errorf("not a valid zip archive: %v", err)
+	}
+	for i, zf := range zr.File {
+		if zf.Name == remoteMember {
+			archiveFormat = "zip"
+			err := zipEntryError(unzipFile(i, zf, destination, strip), i, zf)
+			if verbose {
+				fmt.Printf("Fetched %d of %d bytes in %d range requests\n", ra.fetched, ra.size, ra.requests)
+			}
+			return err
+		}
+	}
+	return fmt.Errorf("%s: no such entry in the archive", remoteMember)
+}
+
+// rangeSize returns the size of the resource at url, after making sure the
+// server supports range requests for it.
+func rangeSize(ctx context.Context, client *http.Client, url string) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	authorize(req)
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if err := responseError(resp); err != nil {
+		return 0, err
+	}
+	if resp.Header.Get("Accept-Ranges") != "bytes" {
+		return 0, errors.New("server doesn't support range requests, as needed for random access")
+	}
+	if resp.ContentLength < 0 {
+		return 0, errors.New("server doesn't report the size, as needed for random access")
+	}
+	return resp.ContentLength, nil
+}
+
+// httpReaderAt is an io.ReaderAt for a remote resource, reading it with
+// range requests. Fetched chunks are cached, so that formats reading a
+// little here and there, like zip, don't make a request per read.
+type httpReaderAt struct {
+	ctx    context.Context
+	client *http.Client
+	url    string
+	size   int64
+
+	mut    sync.Mutex
+	chunks map[int64]*rangeChunk // by chunk number
+	clock  int64                 // for the chunks' last use
+
+	requests int
+	fetched  int64
+}
+
+type rangeChunk struct {
+	data    []byte
+	lastUse int64
+}
+
+// newHTTPReaderAt returns a reader for the resource at url, which must be
+// served with support for range requests.
+func newHTTPReaderAt(ctx context.Context, client *http.Client, url string) (*httpReaderAt, error) {
+	size, err := rangeSize(ctx, client, url)
+	if err != nil {
+		return nil, err
+	}
+	return &httpReaderAt{
+		ctx:    ctx,
+		client: client,
+		url:    url,
+		size:   size,
+		chunks: make(map[int64]*rangeChunk),
+	}, nil
+}
+
+func (r *httpReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	if off >= r.size {
+		return 0, io.EOF
+	}
+	n := 0
+	for n < len(p) && off < r.size {
+		num := off / rangeChunkSize
+		chunk, ok := r.chunks[num]
+		if !ok {
+			// Get all of the chunks this read needs in one go, as
+			// far as the cache holds them.
+			last := (off + int64(len(p)-n) - 1) / rangeChunkSize
+			if last-num >= rangeCacheChunks {
+				last = num + rangeCacheChunks - 1
+			}
+			if err := r.fetch(num, last); err != nil {
+				return n, err
+			}
+			chunk = r.chunks[num]
+		}
+		r.clock++
+		chunk.lastUse = r.clock
+		c := copy(p[n:], chunk.data[off-num*rangeChunkSize:])
+		n += c
+		off += int64(c)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// fetch reads chunks first through last into the cache.
+func (r *httpReaderAt) fetch(first, last int64) error {
+	start := first * rangeChunkSize
+	end := (last + 1) * rangeChunkSize
+	if end > r.size {
+		end = r.size
+	}
+
+	req, err := http.NewRequestWithContext(r.ctx, http.MethodGet, r.url, nil)
+	if err != nil {
+		return err
+	}
+	authorize(req)
+	req.Header.Set("Range", "bytes="+strconv.FormatInt(start, 10)+"-"+strconv.FormatInt(end-1, 10))
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		if err := responseError(resp); err != nil {
+			return err
+		}
+		return fmt.Errorf("range request answered with %s", resp.Status)
+	}
+
+	data := make([]byte, end-start)
+	if _, err := io.ReadFull(resp.Body, data); err != nil {
+		return err
+	}
+	r.requests++
+	r.fetched += int64(len(data))
+
+	for num := first; len(data) > 0; num++ {
+		n := len(data)
+		if n > rangeChunkSize {
+			n = rangeChunkSize
+		}
+		r.clock++
+		r.chunks[num] = &rangeChunk{data: data[:n:n], lastUse: r.clock}
+		data = data[n:]
+	}
+	for len(r.chunks) > rangeCacheChunks {
+		r.evict(first, last)
+	}
+	return nil
+}
+
+// evict drops the least recently used chunk, other than those of first
+// through last, which were just fetched.
+func (r *httpReaderAt) evict(first, last int64) {
+	oldest := int64(-1)
+	for num, chunk := range r.chunks {
+		if num >= first && num <= last {
+			continue
+		}
+		if oldest < 0 || chunk.lastUse < r.chunks[oldest].lastUse {
+			oldest = num
+		}
+	}
+	delete(r.chunks, oldest)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// renames are the -rename-regex rules, applied in order to each entry name
+// after stripping.
+var renames = &renameList{}
+
+// moduleVersion matches the @version of a Go module zip's leading path,
+// as in example.com/mod@v1.2.3/, for -strip-trailing-version.
+var moduleVersion = regexp.MustCompile(`^([^@]+)@v[0-9][^/]*(/|$)`)
+
+type renameRule struct {
+	re   *regexp.Regexp
+	repl string
+}
+
+// renameList is a repeatable flag of PATTERN=REPL rules. The pattern is
+// everything up to the first "=", and the replacement may refer to its
+// groups as $1 and so on.
+type renameList []renameRule
+
+func (l *renameList) String() string {
+	var rules []string
+	for _, r := range *l {
+		rules = append(rules, r.re.String()+"="+r.repl)
+	}
+	return strings.Join(rules, ",")
+}
+
+func (l *renameList) Set(v string) error {
+	pat, repl, ok := strings.Cut(v, "=")
+	if !ok {
+		return errors.New("expected PATTERN=REPL")
+	}
+	re, err := regexp.Compile(pat)
+	if err != nil {
+		return err
+	}
+	*l = append(*l, renameRule{re, repl})
+	return nil
+}
+
+// rename returns name with the rules applied.
+func (l *renameList) rename(name string) string {
+	for _, r := range *l {
+		name = r.re.ReplaceAllString(name, r.repl)
+	}
+	return name
+}
+
+// entryName returns the name an entry named name is extracted as, after
+// -strip, -rename-regex and -prepend, or the reason for skipping it. An
+// empty name stays empty, without a reason.
+func entryName(name string, strip int) (string, string) {
+	if strip > 0 {
+		parts := strings.Split(filepath.ToSlash(name), "/")
+		if len(parts) <= strip {
+			return "", "strip"
+		}
+		name = strings.Join(parts[strip:], "/")
+		if name == "" {
+			return "", "strip"
+		}
+	}
+	if name == "" {
+		return "", ""
+	}
+	if name = renames.rename(name); name == "" {
+		return "", "rename"
+	}
+	return prepend + name, ""
+}
+
+// prepend is the -prepend directory that entries are put in, after
+// stripping and renaming, as a clean relative path ending in a slash.
+var prepend = ""
+
+// parsePrepend returns the -prepend value p in the form of prepend. It may
+// not lead out of the destination.
+func parsePrepend(p string) (string, error) {
+	p = path.Clean(filepath.ToSlash(p))
+	if path.IsAbs(p) || filepath.IsAbs(p) || p == ".." || strings.HasPrefix(p, "../") {
+		return "", errors.New("must be a relative path within the destination")
+	}
+	if p == "." {
+		return "", nil
+	}
+	return p + "/", nil
+}
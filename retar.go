@@ -0,0 +1,39 @@
+package main
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+)
+
+// tarOut, when set, receives the selected and renamed entries as a new tar
+// stream instead of them being extracted to disk.
+var tarOut *tar.Writer
+
+// retarFile writes an entry with header hdr to tarOut under the new name,
+// followed by its contents from r if it's a regular file. The Linkname of a
+// hard link must already be mapped like the names are.
+func retarFile(name string, hdr tar.Header, r io.Reader) error {
+	hdr.Name = name
+	if hdr.PAXRecords != nil {
+		// Records for the path and link target would override the new
+		// ones.
+		recs := make(map[string]string, len(hdr.PAXRecords))
+		for k, v := range hdr.PAXRecords {
+			if k != "path" && k != "linkpath" {
+				recs[k] = v
+			}
+		}
+		hdr.PAXRecords = recs
+	}
+
+	if err := tarOut.WriteHeader(&hdr); err != nil {
+		return fmt.Errorf("%s: writing tar header: %v", name, err)
+	}
+	if hdr.Typeflag == tar.TypeReg || hdr.Typeflag == tar.TypeRegA {
+		if _, err := io.Copy(tarOut, r); err != nil {
+			return fmt.Errorf("%s: writing tar entry: %v", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"syscall"
+	"time"
+)
+
+var (
+	retries      = 0
+	retryMaxTime time.Duration
+
+	// retryMismatch also downloads again on a checksum mismatch, which
+	// may be down to a transfer gone bad rather than the wrong file.
+	retryMismatch = false
+)
+
+const (
+	retryBaseDelay = time.Second
+	retryMaxDelay  = 30 * time.Second
+)
+
+// statusError is returned for unsuccessful HTTP responses.
+type statusError struct {
+	code     int
+	status   string
+	location string // of a redirect the client didn't follow
+}
+
+func (e *statusError) Error() string {
+	if e.location != "" {
+		return fmt.Sprintf("%s: redirect to %s was not followed", e.status, e.location)
+	}
+	return e.status
+}
+
+// responseError returns a *statusError for resp unless it was successful,
+// which is any 2xx status.
+func responseError(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		return nil
+	}
+	se := &statusError{code: resp.StatusCode, status: resp.Status}
+	// Redirects that get here weren't followed, by policy or for lack
+	// of a Location to follow.
+	if resp.StatusCode/100 == 3 {
+		if loc, err := resp.Location(); err == nil {
+			se.location = loc.String()
+		}
+	}
+	return se
+}
+
+// fetch performs the GET request for url, returning the response if it
+// was successful. Failed attempts are retried up to -retries times with
+// exponential backoff, as long as the -retry-max-time budget allows.
+func fetch(ctx context.Context, url string) (*http.Response, error) {
+	start := time.Now()
+	for attempt := 0; ; attempt++ {
+		resp, err := fetchOnce(ctx, url)
+		if err == nil || attempt >= retries || !retryable(err) || ctx.Err() != nil {
+			return resp, err
+		}
+
+		delay := backoff(attempt)
+		if retryMaxTime > 0 && time.Since(start)+delay > retryMaxTime {
+			return nil, fmt.Errorf("%v (giving up after %v)", err, time.Since(start).Round(time.Millisecond))
+		}
+		if verbose {
+			fmt.Printf("Attempt %d failed: %v; retrying in %v\n", attempt+1, err, delay.Round(time.Millisecond))
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// corruptError marks a failure reading the archive itself, as opposed to
+// writing out what's in it, which a fresh download may well fix.
+type corruptError struct {
+	err error
+}
+
+func (e *corruptError) Error() string {
+	return e.err.Error()
+}
+
+func (e *corruptError) Unwrap() error {
+	return e.err
+}
+
+// downloadRetrying downloads and extracts url like download does, starting
+// over from scratch when the archive turns out to be corrupt or truncated,
+// within the same -retries and -retry-max-time limits as for requests.
+// Stdin and local files would just be the same again, so they're not.
+func downloadRetrying(ctx context.Context, url, destination string, strip int) error {
+	start := time.Now()
+	for attempt := 0; ; attempt++ {
+		err := download(ctx, url, destination, strip)
+		var ce *corruptError
+		var me *checksumError
+		mismatch := retryMismatch && errors.As(err, &me)
+		if mismatch {
+			fmt.Printf("Attempt %d: got %s digest %x\n", attempt+1, me.algo, me.got)
+		}
+		if err == nil || attempt >= retries || !errors.As(err, &ce) && !mismatch || url == "-" || localSource || ctx.Err() != nil {
+			return err
+		}
+
+		delay := backoff(attempt)
+		if retryMaxTime > 0 && time.Since(start)+delay > retryMaxTime {
+			return fmt.Errorf("%v (giving up after %v)", err, time.Since(start).Round(time.Millisecond))
+		}
+		if verbose {
+			fmt.Printf("Archive corrupt: %v; downloading again in %v\n", err, delay.Round(time.Millisecond))
+		}
+
+		// Start over with nothing of the previous attempt, including
+		// a cached copy of the bad archive.
+		if err := os.RemoveAll(destination); err != nil {
+			return err
+		}
+		if cacheDir != "" {
+			os.Remove(cachePath(url))
+		}
+		overwritten.reset()
+		skipped.reset()
+		extracted.reset()
+		if extractJournal != nil {
+			extractJournal.reset()
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// backoff returns the delay before the retry following the given attempt.
+// It uses "full jitter", a random delay up to the exponentially growing cap,
+// so that many clients failing at once don't all come back at once.
+func backoff(attempt int) time.Duration {
+	ceiling := retryMaxDelay
+	if attempt < 16 && retryBaseDelay<<attempt < ceiling {
+		ceiling = retryBaseDelay << attempt
+	}
+	return time.Duration(rand.Int63n(int64(ceiling)))
+}
+
+// retryable returns true for errors that may well go away by themselves:
+// network trouble, server errors and rate limiting. Anything else, like a
+// bad certificate or URL or a policy such as -max-size, would only fail the
+// same way again.
+func retryable(err error) bool {
+	var se *statusError
+	if errors.As(err, &se) {
+		return se.code >= 500 || se.code == http.StatusTooManyRequests
+	}
+	// The client wraps everything in a *url.Error, which passes for a
+	// net.Error itself.
+	var ue *url.Error
+	if errors.As(err, &ue) {
+		err = ue.Err
+	}
+	var cve *tls.CertificateVerificationError
+	if errors.As(err, &cve) {
+		return false
+	}
+	var oe *net.OpError
+	var ne net.Error
+	return errors.As(err, &oe) || errors.As(err, &ne) && ne.Timeout() ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET)
+}
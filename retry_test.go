@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestResponseStatus(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("archive"))
+	})
+	mux.HandleFunc("/nocontent", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("/partial", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Range", "bytes 0-6/7")
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write([]byte("archive"))
+	})
+	mux.HandleFunc("/moved", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/ok", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/noloc", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/missing", http.NotFound)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	client = newClient()
+
+	cases := []struct {
+		path       string
+		noRedirect bool
+		wantErr    string
+	}{
+		{"/ok", false, ""},
+		{"/nocontent", false, ""},
+		{"/partial", false, ""},
+		{"/moved", false, ""},
+		{"/moved", true, "301 Moved Permanently: redirect to " + srv.URL + "/ok was not followed"},
+		{"/noloc", false, "301 Moved Permanently"},
+		{"/missing", false, "404 Not Found"},
+	}
+	for _, tc := range cases {
+		noRedirect = tc.noRedirect
+		resp, err := fetchOnce(context.Background(), srv.URL+tc.path)
+		noRedirect = false
+		if err == nil {
+			resp.Body.Close()
+		}
+		switch {
+		case tc.wantErr == "" && err != nil:
+			t.Errorf("%s: unexpected error %v", tc.path, err)
+		case tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)):
+			t.Errorf("%s: error %v, expected %q", tc.path, err, tc.wantErr)
+		}
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	urlError := func(err error) error {
+		return &url.Error{Op: "Get", URL: "https://example.com/a.tar.gz", Err: err}
+	}
+	cases := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"server error", &statusError{code: 500, status: "500 Internal Server Error"}, true},
+		{"unavailable", &statusError{code: 503, status: "503 Service Unavailable"}, true},
+		{"rate limited", &statusError{code: 429, status: "429 Too Many Requests"}, true},
+		{"not found", &statusError{code: 404, status: "404 Not Found"}, false},
+		{"forbidden", &statusError{code: 403, status: "403 Forbidden"}, false},
+		{"connection refused", urlError(&net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}), true},
+		{"connection reset", urlError(fmt.Errorf("read: %w", syscall.ECONNRESET)), true},
+		{"cut off", urlError(io.ErrUnexpectedEOF), true},
+		{"closed", urlError(io.EOF), true},
+		{"bad certificate", urlError(&tls.CertificateVerificationError{Err: errors.New("unknown authority")}), false},
+		{"bad scheme", urlError(errors.New("unsupported protocol scheme")), false},
+		{"too large", fmt.Errorf("archive size %d is larger than the -max-size of %d bytes", 2, 1), false},
+	}
+	for _, tc := range cases {
+		if got := retryable(tc.err); got != tc.want {
+			t.Errorf("%s: retryable(%v) = %v, expected %v", tc.name, tc.err, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A gzip compressed stream may be a single file rather than a tar archive.
+// It's then extracted as if it were an archive with just that one file in
+// it, named and dated like gunzip -N does from the gzip header.
+
+// isTar returns true if br starts with a tar header, judging by its
+// checksum since old tar formats have no magic.
+func isTar(br *bufio.Reader) bool {
+	head, err := br.Peek(512)
+	if err != nil {
+		return false
+	}
+	if bytes.Count(head, []byte{0}) == len(head) {
+		// The end of an empty archive.
+		return true
+	}
+	want, err := strconv.ParseInt(strings.Trim(string(head[148:156]), " \x00"), 8, 64)
+	if err != nil {
+		return false
+	}
+	// The checksum is calculated with the checksum field itself as
+	// spaces.
+	var sum int64
+	for i, c := range head {
+		if i >= 148 && i < 156 {
+			c = ' '
+		}
+		sum += int64(c)
+	}
+	return sum == want
+}
+
+// singleFileReader is an entryReader for an "archive" of just one file.
+type singleFileReader struct {
+	io.Reader
+	hdr *tar.Header
+}
+
+func (s *singleFileReader) Next() (*tar.Header, error) {
+	if s.hdr == nil {
+		return nil, io.EOF
+	}
+	hdr := s.hdr
+	s.hdr = nil
+	return hdr, nil
+}
+
+// extractSingle extracts the single gzip compressed file read from r, as
+// decompressed by gz, into destination.
+func extractSingle(ctx context.Context, url string, gz *gzip.Reader, r io.Reader, destination string) error {
+	name := path.Base(filepath.ToSlash(gz.Name))
+	if gz.Name == "" || name == "." || name == "/" || name == ".." {
+		name = strings.TrimSuffix(urlFileName(url), ".gz")
+		if url == "-" || name == "" {
+			name = "data"
+		}
+	}
+	if verbose {
+		fmt.Println("Single compressed file", name)
+	}
+
+	hdr := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0644,
+		Size:     -1, // unknown until decompressed
+		ModTime:  gz.ModTime,
+	}
+	if tarOut != nil {
+		// The tar header needs the size up front.
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return &corruptError{err}
+		}
+		hdr.Size = int64(len(data))
+		r = bytes.NewReader(data)
+	}
+
+	// Stripping would leave nothing of a single name.
+	if err := unarchive(ctx, &singleFileReader{r, hdr}, "gzip", destination, 0); err != nil {
+		return err
+	}
+
+	if gz.ModTime.IsZero() || listEntry != nil || tarOut != nil || dryRun {
+		return nil
+	}
+	fpath := filepath.Join(destination, name)
+	if _, err := os.Lstat(fpath); err != nil {
+		// Filtered out.
+		return nil
+	}
+	mtime := effectiveModTime(gz.ModTime)
+	if err := os.Chtimes(fpath, time.Now(), mtime); err != nil {
+		return fmt.Errorf("%s: setting modification time: %v", fpath, err)
+	}
+	return nil
+}
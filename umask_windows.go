@@ -0,0 +1,8 @@
+package main
+
+import "os"
+
+// currentUmask returns zero; there is no umask on Windows.
+func currentUmask() os.FileMode {
+	return 0
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+)
+
+// verifyExtracted, for -verify-extracted, has every extracted file read
+// back once the extraction is done and checked against what the archive
+// says about it: the size, and for zip entries the CRC-32 as well.
+var verifyExtracted = false
+
+// extracted are the files written so far, for -verify-extracted.
+var extracted = &extractedFiles{}
+
+type extractedFiles struct {
+	mut   sync.Mutex
+	files map[string]expectedFile // by path, the last entry written to it
+	order []string
+}
+
+type expectedFile struct {
+	size   int64 // or -1 if unknown, as for single compressed files
+	crc    uint32
+	hasCRC bool
+}
+
+// add records that fpath was written with size bytes, with the given
+// CRC-32 if hasCRC is set.
+func (e *extractedFiles) add(fpath string, size int64, crc uint32, hasCRC bool) {
+	if !verifyExtracted {
+		return
+	}
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	if e.files == nil {
+		e.files = make(map[string]expectedFile)
+	}
+	if _, ok := e.files[fpath]; !ok {
+		e.order = append(e.order, fpath)
+	}
+	e.files[fpath] = expectedFile{size, crc, hasCRC}
+}
+
+func (e *extractedFiles) reset() {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	e.files = nil
+	e.order = nil
+}
+
+// verify reads back every file recorded, returning the mismatches.
+func (e *extractedFiles) verify() error {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	var errs entryErrors
+	for _, fpath := range e.order {
+		if err := e.files[fpath].check(fpath); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if verbose {
+		fmt.Printf("Verified %d extracted files, %d mismatched\n", len(e.order), len(errs))
+	}
+	return errs.orNil()
+}
+
+func (x expectedFile) check(fpath string) error {
+	fd, err := os.Open(fpath)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	h := crc32.NewIEEE()
+	n, err := io.Copy(h, bufio.NewReader(fd))
+	if err != nil {
+		return fmt.Errorf("%s: reading back: %v", fpath, err)
+	}
+	if x.size >= 0 && n != x.size {
+		return fmt.Errorf("%s: size is %d, archive says %d", fpath, n, x.size)
+	}
+	if x.hasCRC && h.Sum32() != x.crc {
+		return fmt.Errorf("%s: CRC32 is %08x, archive says %08x", fpath, h.Sum32(), x.crc)
+	}
+	return nil
+}
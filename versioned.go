@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// versionPattern finds a version number like 1.2 or v1.27.0 in a file name.
+var versionPattern = regexp.MustCompile(`v?[0-9]+(\.[0-9]+)+`)
+
+// versionFromURL returns the first version number in the file name of url,
+// or an empty string if there is none.
+func versionFromURL(url string) string {
+	return versionPattern.FindString(urlFileName(url))
+}
+
+// checkVersion returns an error unless version is usable as a single
+// directory name.
+func checkVersion(version string) error {
+	if version == "" || version == "." || version == ".." || strings.ContainsAny(version, `/\`) {
+		return fmt.Errorf("%q is not usable as a directory name", version)
+	}
+	return nil
+}
+
+// updateCurrentLink points the "current" symlink in dir at version. The new
+// link is created next to the old one and renamed over it, so there's
+// always a current version.
+func updateCurrentLink(dir, version string) error {
+	link := filepath.Join(dir, "current")
+	tmp := link + ".tmp"
+	os.Remove(tmp)
+	if err := os.Symlink(version, tmp); err != nil {
+		return fmt.Errorf("%s: creating symlink: %v", link, err)
+	}
+	if err := os.Rename(tmp, link); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("%s: moving symlink into place: %v", link, err)
+	}
+	return nil
+}
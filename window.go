@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// skipBytes and limitBytes select a raw window of the downloaded data, for
+// -skip and -limit. This is bytes of the archive file, not entries of it;
+// extracting a window only works if it happens to hold a whole archive.
+var (
+	skipBytes  int64 = 0
+	limitBytes int64 = 0
+)
+
+func windowed() bool {
+	return skipBytes > 0 || limitBytes > 0
+}
+
+// setRange asks for just the -skip/-limit window of the resource.
+func setRange(req *http.Request) {
+	if !windowed() {
+		return
+	}
+	if limitBytes > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", skipBytes, skipBytes+limitBytes-1))
+	} else {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", skipBytes))
+	}
+}
+
+// checkUnrequestedRange returns an error unless a 206 Partial Content
+// response to a request without a Range, as over-eager caching proxies like
+// to send, is the whole resource anyway according to its Content-Range.
+func checkUnrequestedRange(resp *http.Response) error {
+	if resp.StatusCode != http.StatusPartialContent || resp.Request.Header.Get("Range") != "" {
+		return nil
+	}
+	cr := resp.Header.Get("Content-Range")
+	if first, last, size, ok := parseContentRange(cr); !ok || first != 0 || last != size-1 {
+		return fmt.Errorf("%s without a range being requested, for %q rather than the whole archive", resp.Status, cr)
+	}
+	return nil
+}
+
+// parseContentRange parses a Content-Range of the form "bytes
+// first-last/size". An unknown size ("*") doesn't parse.
+func parseContentRange(cr string) (first, last, size int64, ok bool) {
+	rng, ok := strings.CutPrefix(cr, "bytes ")
+	if !ok {
+		return 0, 0, 0, false
+	}
+	rng, total, _ := strings.Cut(rng, "/")
+	from, to, _ := strings.Cut(rng, "-")
+	var err1, err2, err3 error
+	first, err1 = strconv.ParseInt(strings.TrimSpace(from), 10, 64)
+	last, err2 = strconv.ParseInt(strings.TrimSpace(to), 10, 64)
+	size, err3 = strconv.ParseInt(strings.TrimSpace(total), 10, 64)
+	if err1 != nil || err2 != nil || err3 != nil || first > last || last >= size {
+		return 0, 0, 0, false
+	}
+	return first, last, size, true
+}
+
+// window returns the -skip/-limit window of r, for sources that didn't
+// apply it already.
+func window(r io.ReadCloser) io.ReadCloser {
+	if !windowed() {
+		return r
+	}
+	return &windowReader{rc: r, skip: skipBytes}
+}
+
+type windowReader struct {
+	rc   io.ReadCloser
+	skip int64
+	r    io.Reader
+	err  error
+}
+
+func (w *windowReader) Read(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	if w.r == nil {
+		if n, err := io.CopyN(io.Discard, w.rc, w.skip); err != nil {
+			w.err = err
+			if err == io.EOF {
+				w.err = fmt.Errorf("data ends at %d bytes, before the -skip of %d", n, w.skip)
+			}
+			return 0, w.err
+		}
+		w.r = w.rc
+		if limitBytes > 0 {
+			w.r = io.LimitReader(w.rc, limitBytes)
+		}
+	}
+	return w.r.Read(p)
+}
+
+func (w *windowReader) Close() error {
+	return w.rc.Close()
+}
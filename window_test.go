@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseContentRange(t *testing.T) {
+	cases := []struct {
+		cr                string
+		first, last, size int64
+		ok                bool
+	}{
+		{"bytes 0-99/100", 0, 99, 100, true},
+		{"bytes 10-19/100", 10, 19, 100, true},
+		{"bytes 0-99/*", 0, 0, 0, false},
+		{"bytes 0-100/100", 0, 0, 0, false},
+		{"bytes 50-10/100", 0, 0, 0, false},
+		{"bytes */100", 0, 0, 0, false},
+		{"items 0-99/100", 0, 0, 0, false},
+		{"", 0, 0, 0, false},
+	}
+	for _, tc := range cases {
+		first, last, size, ok := parseContentRange(tc.cr)
+		if first != tc.first || last != tc.last || size != tc.size || ok != tc.ok {
+			t.Errorf("%q: got %d-%d/%d %v", tc.cr, first, last, size, ok)
+		}
+	}
+}
+
+func TestUnrequestedPartialContent(t *testing.T) {
+	data := tarData(t, tarEntry{name: "file", body: strings.Repeat("x", 2000)})
+	// An over-eager caching proxy, answering 206 to a plain GET.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		first, last := 0, len(data)-1
+		if r.URL.Path == "/part.tar" {
+			last = len(data) / 2
+		}
+		if r.URL.Path != "/none.tar" {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", first, last, len(data)))
+		}
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(data[first : last+1])
+	}))
+	defer srv.Close()
+
+	cases := []struct {
+		path    string
+		wantErr bool
+	}{
+		{"/whole.tar", false},
+		{"/part.tar", true},
+		{"/none.tar", true},
+	}
+	for _, tc := range cases {
+		_, err := downloadTest(context.Background(), t, srv.URL+tc.path, 0)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: error %v, expected error %v", tc.path, err, tc.wantErr)
+		} else if err != nil && !strings.Contains(err.Error(), "without a range being requested") {
+			t.Errorf("%s: unclear error %v", tc.path, err)
+		}
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package main
+
+// setXattr does nothing; extended attributes are only restored on Linux.
+func setXattr(path, name, value string) error {
+	return nil
+}
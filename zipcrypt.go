@@ -0,0 +1,341 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+)
+
+// Decryption of password protected zip entries, which archive/zip doesn't
+// handle. Both the legacy PKWARE "ZipCrypto" scheme and WinZip AES
+// (https://www.winzip.com/en/support/aes-encryption/) are supported.
+
+const (
+	zipFlagEncrypted       = 0x1
+	zipFlagDataDescriptor  = 0x8
+	zipFlagStrongEncrypted = 0x40
+	zipMethodAES           = 99
+	zipExtraAES            = 0x9901
+)
+
+var errWrongPassword = errors.New("wrong password")
+
+// openZipFile opens zf for reading, decrypting it with the -password if
+// it's encrypted.
+func openZipFile(zf *zip.File) (io.ReadCloser, error) {
+	if zf.Flags&zipFlagEncrypted == 0 && zf.Method != zipMethodAES {
+		return zf.Open()
+	}
+	if zf.Flags&zipFlagStrongEncrypted != 0 {
+		return nil, errors.New("unsupported encryption method (PKWARE strong encryption)")
+	}
+	if zipPassword == "" {
+		return nil, errors.New("entry is encrypted; a -password is required")
+	}
+
+	// Decryption is streamed, like decompression, so that a large entry
+	// isn't held in memory, let alone several with parallel extraction.
+	raw, err := zf.OpenRaw()
+	if err != nil {
+		return nil, err
+	}
+
+	var r io.Reader
+	var ar *aesReader
+	method := zf.Method
+	checkCRC := true
+	if zf.Method == zipMethodAES {
+		var version uint16
+		ar, method, version, err = newAESReader(zf, raw)
+		r = ar
+		// AE-2 zeroes out the CRC in favor of the authentication code.
+		checkCRC = version == 1
+	} else {
+		r, err = newZipCryptoReader(zf, raw)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var rc io.ReadCloser
+	switch method {
+	case zip.Store:
+		rc = ioutil.NopCloser(r)
+	case zip.Deflate:
+		rc = flate.NewReader(r)
+	default:
+		return nil, fmt.Errorf("unsupported compression method %d", method)
+	}
+	if ar != nil {
+		rc = &authReader{rc: rc, ar: ar}
+	}
+	if checkCRC {
+		rc = &crcReader{rc: rc, hash: crc32.NewIEEE(), want: zf.CRC32}
+	}
+	return rc, nil
+}
+
+// zipHasCRC returns false for AE-2 encrypted entries, which zero out the
+// CRC in favor of the authentication code.
+func zipHasCRC(zf *zip.File) bool {
+	if zf.Method != zipMethodAES {
+		return true
+	}
+	version, _, _, ok := aesExtra(zf.Extra)
+	return ok && version == 1
+}
+
+// zipCryptoReader decrypts data using the traditional PKWARE scheme. It's
+// weak, but still what most tools produce by default.
+type zipCryptoReader struct {
+	r    io.Reader
+	keys [3]uint32
+}
+
+// newZipCryptoReader returns a reader for the decrypted contents of the
+// encrypted entry zf, read from raw, after checking the password against
+// the encryption header.
+func newZipCryptoReader(zf *zip.File, raw io.Reader) (*zipCryptoReader, error) {
+	z := &zipCryptoReader{r: raw, keys: [3]uint32{0x12345678, 0x23456789, 0x34567890}}
+	for _, c := range []byte(zipPassword) {
+		z.update(c)
+	}
+
+	var header [12]byte
+	if _, err := io.ReadFull(raw, header[:]); err != nil {
+		return nil, errors.New("encrypted entry too short")
+	}
+	z.decrypt(header[:])
+
+	// The last byte of the encryption header is a check byte: the high
+	// byte of the CRC, or of the modification time when the CRC is
+	// only known afterwards.
+	check := byte(zf.CRC32 >> 24)
+	if zf.Flags&zipFlagDataDescriptor != 0 {
+		check = byte(zf.ModifiedTime >> 8)
+	}
+	if header[11] != check {
+		return nil, errWrongPassword
+	}
+	return z, nil
+}
+
+func (z *zipCryptoReader) Read(p []byte) (int, error) {
+	n, err := z.r.Read(p)
+	z.decrypt(p[:n])
+	return n, err
+}
+
+// decrypt decrypts data in place.
+func (z *zipCryptoReader) decrypt(data []byte) {
+	for i, c := range data {
+		t := z.keys[2] | 2
+		data[i] = c ^ byte((t*(t^1))>>8)
+		z.update(data[i])
+	}
+}
+
+func (z *zipCryptoReader) update(c byte) {
+	z.keys[0] = crc32Byte(z.keys[0], c)
+	z.keys[1] = (z.keys[1]+z.keys[0]&0xff)*134775813 + 1
+	z.keys[2] = crc32Byte(z.keys[2], byte(z.keys[1]>>24))
+}
+
+func crc32Byte(crc uint32, c byte) uint32 {
+	return crc32.IEEETable[byte(crc)^c] ^ crc>>8
+}
+
+// aesAuthLen is the length of the authentication code following the
+// ciphertext of a WinZip AES entry.
+const aesAuthLen = 10
+
+var errAESAuth = errors.New("authentication failed; archive corrupt or wrong password")
+
+// aesReader decrypts an entry according to the WinZip AES specification,
+// checking the authentication code that follows the ciphertext once it's
+// all been read.
+type aesReader struct {
+	r      io.Reader
+	remain int64 // of the ciphertext
+	block  cipher.Block
+	mac    hash.Hash
+	// CTR mode, but with a little endian counter starting at one, so
+	// crypto/cipher's CTR doesn't apply.
+	counter, stream [aes.BlockSize]byte
+	used            int // of stream
+	err             error
+}
+
+// newAESReader returns a reader for the decrypted contents of the
+// encrypted entry zf, read from raw, along with the actual compression
+// method and the AE version.
+func newAESReader(zf *zip.File, raw io.Reader) (*aesReader, uint16, uint16, error) {
+	version, strength, method, ok := aesExtra(zf.Extra)
+	if !ok {
+		return nil, 0, 0, errors.New("unsupported encryption method (missing AES parameters)")
+	}
+	var keyLen int
+	switch strength {
+	case 1:
+		keyLen = 16
+	case 2:
+		keyLen = 24
+	case 3:
+		keyLen = 32
+	default:
+		return nil, 0, 0, fmt.Errorf("unsupported encryption method (AES strength %d)", strength)
+	}
+
+	saltLen := keyLen / 2
+	ciphertextLen := int64(zf.CompressedSize64) - int64(saltLen) - 2 - aesAuthLen
+	head := make([]byte, saltLen+2)
+	if _, err := io.ReadFull(raw, head); err != nil || ciphertextLen < 0 {
+		return nil, 0, 0, errors.New("encrypted entry too short")
+	}
+	salt, verifier := head[:saltLen], head[saltLen:]
+
+	key, err := pbkdf2.Key(sha1.New, zipPassword, salt, 1000, 2*keyLen+2)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if !bytes.Equal(key[2*keyLen:], verifier) {
+		return nil, 0, 0, errWrongPassword
+	}
+
+	block, err := aes.NewCipher(key[:keyLen])
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	return &aesReader{
+		r:      raw,
+		remain: ciphertextLen,
+		block:  block,
+		mac:    hmac.New(sha1.New, key[keyLen:2*keyLen]),
+		used:   aes.BlockSize,
+	}, method, version, nil
+}
+
+func (a *aesReader) Read(p []byte) (int, error) {
+	if a.err != nil {
+		return 0, a.err
+	}
+	if a.remain == 0 {
+		a.err = a.authenticate()
+		return 0, a.err
+	}
+	if int64(len(p)) > a.remain {
+		p = p[:a.remain]
+	}
+	n, err := a.r.Read(p)
+	a.remain -= int64(n)
+	a.mac.Write(p[:n])
+	for i := range p[:n] {
+		if a.used == aes.BlockSize {
+			for j := range a.counter {
+				a.counter[j]++
+				if a.counter[j] != 0 {
+					break
+				}
+			}
+			a.block.Encrypt(a.stream[:], a.counter[:])
+			a.used = 0
+		}
+		p[i] ^= a.stream[a.used]
+		a.used++
+	}
+	if err == io.EOF && a.remain > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	if err == nil && a.remain == 0 {
+		err = a.authenticate()
+	}
+	if err != nil {
+		a.err = err
+	}
+	return n, err
+}
+
+// authenticate checks the authentication code following the ciphertext,
+// returning io.EOF if it's good.
+func (a *aesReader) authenticate() error {
+	var code [aesAuthLen]byte
+	if _, err := io.ReadFull(a.r, code[:]); err != nil {
+		return io.ErrUnexpectedEOF
+	}
+	if !hmac.Equal(a.mac.Sum(nil)[:aesAuthLen], code[:]) {
+		return errAESAuth
+	}
+	return io.EOF
+}
+
+// authReader reads the rest of ar, and so checks its authentication code,
+// once rc reaches EOF; the decompressor may well stop short of the end of
+// what it reads from.
+type authReader struct {
+	rc io.ReadCloser
+	ar *aesReader
+}
+
+func (r *authReader) Read(p []byte) (int, error) {
+	n, err := r.rc.Read(p)
+	if err == io.EOF {
+		if _, aerr := io.Copy(io.Discard, r.ar); aerr != nil {
+			return n, aerr
+		}
+	}
+	return n, err
+}
+
+func (r *authReader) Close() error {
+	return r.rc.Close()
+}
+
+// aesExtra finds the AES parameters among the zip extra fields.
+func aesExtra(extra []byte) (version uint16, strength byte, method uint16, ok bool) {
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra)
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		extra = extra[4:]
+		if size > len(extra) {
+			break
+		}
+		if id == zipExtraAES && size >= 7 {
+			return binary.LittleEndian.Uint16(extra), extra[4], binary.LittleEndian.Uint16(extra[5:]), true
+		}
+		extra = extra[size:]
+	}
+	return 0, 0, 0, false
+}
+
+// crcReader verifies the CRC32 of everything read through it when reaching
+// EOF.
+type crcReader struct {
+	rc   io.ReadCloser
+	hash hash.Hash32
+	want uint32
+}
+
+func (r *crcReader) Read(p []byte) (int, error) {
+	n, err := r.rc.Read(p)
+	r.hash.Write(p[:n])
+	if err == io.EOF && r.hash.Sum32() != r.want {
+		return n, zip.ErrChecksum
+	}
+	return n, err
+}
+
+func (r *crcReader) Close() error {
+	return r.rc.Close()
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var magicZstd = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+// Skippable frames, as used by the seekable zstd format to hold its seek
+// table, have magic numbers 0x184D2A50 through 0x184D2A5F.
+const (
+	zstdSkippableMagic = 0x184d2a50
+	zstdSkippableMask  = 0xfffffff0
+)
+
+// isZstd returns true if br starts with a zstd frame, skippable or not.
+func isZstd(br *bufio.Reader) bool {
+	head, err := br.Peek(4)
+	if err != nil {
+		return false
+	}
+	return hasMagic(br, magicZstd) || binary.LittleEndian.Uint32(head)&zstdSkippableMask == zstdSkippableMagic
+}
+
+// zstdDict is the dictionary given with -zstd-dict, in the format of
+// "zstd --train", and zstdDictID its ID.
+var (
+	zstdDict   []byte
+	zstdDictID uint32
+)
+
+// errZstdDict is returned for archives compressed with a dictionary other
+// than the one given, if any.
+var errZstdDict = errors.New("compressed with dictionary")
+
+// loadZstdDict reads the dictionary for -zstd-dict from the file at path.
+func loadZstdDict(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	dict, err := zstd.InspectDictionary(data)
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	zstdDict, zstdDictID = data, dict.ID()
+	return nil
+}
+
+// zstdReader returns a reader for the decompressed contents of the zstd
+// stream in br. The decoder skips skippable frames, as used by the
+// seekable format for its seek table.
+func zstdReader(br *bufio.Reader) (io.Reader, error) {
+	id := zstdDictionaryID(br)
+	switch {
+	case id != 0 && zstdDict == nil:
+		return nil, fmt.Errorf("zstd: %w %d; give it with -zstd-dict", errZstdDict, id)
+	case id != 0 && id != zstdDictID:
+		return nil, fmt.Errorf("zstd: %w %d, not the -zstd-dict one (%d)", errZstdDict, id, zstdDictID)
+	}
+	// A single goroutine, as nothing closes the decoder.
+	opts := []zstd.DOption{zstd.WithDecoderConcurrency(1)}
+	if zstdDict != nil {
+		opts = append(opts, zstd.WithDecoderDicts(zstdDict))
+	}
+	return zstd.NewReader(br, opts...)
+}
+
+// zstdDictionaryID returns the dictionary ID of the first data frame in br,
+// past any skippable frames, or zero if it has none or it can't be seen
+// within the buffer.
+func zstdDictionaryID(br *bufio.Reader) uint32 {
+	off := 0
+	for {
+		head, err := br.Peek(off + 8)
+		if err != nil {
+			return 0
+		}
+		magic := binary.LittleEndian.Uint32(head[off:])
+		if magic&zstdSkippableMask != zstdSkippableMagic {
+			break
+		}
+		off += 8 + int(binary.LittleEndian.Uint32(head[off+4:]))
+	}
+
+	// The frame header descriptor says how many bytes of dictionary ID
+	// follow it, after a window descriptor unless it's a single segment.
+	head, err := br.Peek(off + 4 + 1 + 1 + 4)
+	if err != nil || !hasMagicAt(head, off, magicZstd) {
+		return 0
+	}
+	desc := head[off+4]
+	pos := off + 5
+	if desc&0x20 == 0 {
+		pos++
+	}
+	switch desc & 0x03 {
+	case 1:
+		return uint32(head[pos])
+	case 2:
+		return uint32(binary.LittleEndian.Uint16(head[pos:]))
+	case 3:
+		return binary.LittleEndian.Uint32(head[pos:])
+	}
+	return 0
+}
+
+func hasMagicAt(data []byte, off int, magic []byte) bool {
+	return len(data) >= off+len(magic) && string(data[off:off+len(magic)]) == string(magic)
+}
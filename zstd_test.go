@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func zstdData(t *testing.T, data, dict []byte) []byte {
+	t.Helper()
+	var opts []zstd.EOption
+	if dict != nil {
+		opts = append(opts, zstd.WithEncoderDict(dict))
+	}
+	zw, err := zstd.NewWriter(nil, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return zw.EncodeAll(data, nil)
+}
+
+func TestZstdReader(t *testing.T) {
+	content := bytes.Repeat([]byte("some zstd compressed content\n"), 100)
+	var samples [][]byte
+	for i := 0; i < 100; i++ {
+		samples = append(samples, []byte(fmt.Sprintf("sample %d of some zstd compressed content %x\n", i, i*i*7919)))
+	}
+	dict, err := zstd.BuildDict(zstd.BuildDictOptions{ID: 1234, Contents: samples, History: content})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := zstd.BuildDict(zstd.BuildDictOptions{ID: 5678, Contents: samples, History: content})
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain := zstdData(t, content, nil)
+	withDict := zstdData(t, content, dict)
+	skippable := append([]byte("\x5e\x2a\x4d\x18\x03\x00\x00\x00abc"), plain...)
+
+	cases := []struct {
+		name    string
+		data    []byte
+		dict    []byte
+		dictID  uint32
+		wantErr bool
+	}{
+		{"plain", plain, nil, 0, false},
+		{"skippable frame first", skippable, nil, 0, false},
+		{"dictionary", withDict, dict, 1234, false},
+		{"missing dictionary", withDict, nil, 1234, true},
+		{"wrong dictionary", withDict, other, 1234, true},
+		{"unneeded dictionary", plain, dict, 0, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			zstdDict, zstdDictID = tc.dict, 0
+			if tc.dict != nil {
+				d, err := zstd.InspectDictionary(tc.dict)
+				if err != nil {
+					t.Fatal(err)
+				}
+				zstdDictID = d.ID()
+			}
+			defer func() { zstdDict, zstdDictID = nil, 0 }()
+
+			br := bufio.NewReader(bytes.NewReader(tc.data))
+			if !isZstd(br) {
+				t.Fatal("not recognized as zstd")
+			}
+			if id := zstdDictionaryID(br); id != tc.dictID {
+				t.Errorf("dictionary ID %d, expected %d", id, tc.dictID)
+			}
+			r, err := zstdReader(br)
+			if err == nil {
+				var got []byte
+				if got, err = ioutil.ReadAll(r); err == nil && !bytes.Equal(got, content) {
+					t.Errorf("got %d bytes of wrong content", len(got))
+				}
+			}
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Errorf("error %v, expected error %v", err, tc.wantErr)
+			}
+		})
+	}
+}